@@ -0,0 +1,17 @@
+package api
+
+import (
+	"net/http"
+
+	conf "github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+type MarathonAPI struct {
+	Config *conf.Configuration
+}
+
+/* Reports the health of the endpoints of every Marathon cluster */
+func (m *MarathonAPI) Endpoints(w http.ResponseWriter, r *http.Request) {
+	responseJSON(w, marathon.ClusterEndpointStatus(m.Config.MarathonClusters()))
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/zenazn/goji/web"
+
+	conf "github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/qzk"
+	"github.com/seomoz/roger-bamboo/services/haproxy"
+	"github.com/seomoz/roger-bamboo/services/ports"
+	"github.com/seomoz/roger-bamboo/services/service"
+	"github.com/seomoz/roger-bamboo/services/source"
+)
+
+type PortsAPI struct {
+	Config    *conf.Configuration
+	Store     service.Store
+	Zookeeper *zk.Conn
+	Source    source.Source
+}
+
+type PortsState struct {
+	// App ids by external TCP port, once conflicts are resolved
+	Used         map[string]string
+	Conflicts    []ports.Conflict
+	Reservations map[string]string
+}
+
+type PortReservation struct {
+	Port  string
+	AppId string
+}
+
+func (p *PortsAPI) Get(w http.ResponseWriter, r *http.Request) {
+	templateData, err := haproxy.GetTemplateData(p.Config, p.Store, p.Zookeeper, p.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	reservations := map[string]string{}
+	if p.Config.Bamboo.PortReservationPath != "" {
+		reservations, err = ports.Reservations(p.Zookeeper, p.Config.Bamboo.PortReservationPath)
+		if err != nil {
+			responseError(w, err.Error())
+			return
+		}
+	}
+
+	responseJSON(w, PortsState{
+		Used:         ports.Used(templateData.Apps),
+		Conflicts:    templateData.PortConflicts,
+		Reservations: reservations,
+	})
+}
+
+func (p *PortsAPI) Reserve(w http.ResponseWriter, r *http.Request) {
+	if p.Config.Bamboo.PortReservationPath == "" {
+		responseError(w, "Port reservations are disabled")
+		return
+	}
+
+	reservation, err := extractPortReservation(r)
+	if err != nil {
+		responseError(w, err.Error())
+		return
+	}
+
+	acl, err := qzk.ACL(p.Config.Bamboo.Zookeeper)
+	if err != nil {
+		responseError(w, err.Error())
+		return
+	}
+
+	err = ports.Reserve(p.Zookeeper, p.Config.Bamboo.PortReservationPath, acl, reservation.Port, reservation.AppId)
+	if err == zk.ErrNodeExists {
+		responseError(w, "Port is already reserved")
+		return
+	}
+	if err != nil {
+		responseError(w, err.Error())
+		return
+	}
+
+	responseJSON(w, reservation)
+}
+
+func (p *PortsAPI) Release(c web.C, w http.ResponseWriter, r *http.Request) {
+	if p.Config.Bamboo.PortReservationPath == "" {
+		responseError(w, "Port reservations are disabled")
+		return
+	}
+
+	err := ports.Release(p.Zookeeper, p.Config.Bamboo.PortReservationPath, c.URLParams["port"])
+	if err != nil {
+		responseError(w, err.Error())
+		return
+	}
+
+	responseJSON(w, new(map[string]string))
+}
+
+func extractPortReservation(r *http.Request) (PortReservation, error) {
+	var reservation PortReservation
+	payload, _ := ioutil.ReadAll(r.Body)
+
+	err := json.Unmarshal(payload, &reservation)
+	if err != nil {
+		return reservation, errors.New("Unable to decode JSON request")
+	}
+
+	return reservation, nil
+}
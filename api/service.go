@@ -6,21 +6,22 @@ import (
 	"net/url"
 	"net/http"
 	"io/ioutil"
+	"strconv"
+	"strings"
 
 	"github.com/zenazn/goji/web"
-	"github.com/samuel/go-zookeeper/zk"
 
 	conf "github.com/seomoz/roger-bamboo/configuration"
 	service "github.com/seomoz/roger-bamboo/services/service"
 )
 
 type ServiceAPI struct {
-	Config    *conf.Configuration
-	Zookeeper *zk.Conn
+	Config *conf.Configuration
+	Store  service.Store
 }
 
 func (d *ServiceAPI) All(w http.ResponseWriter, r *http.Request) {
-	services, err := service.All(d.Zookeeper, d.Config.Bamboo.Zookeeper)
+	services, err := d.Store.All()
 
 	if err != nil {
 		responseError(w, err.Error())
@@ -30,6 +31,19 @@ func (d *ServiceAPI) All(w http.ResponseWriter, r *http.Request) {
 	responseJSON(w, services)
 }
 
+// Responds with the version of the service as ETag, for If-Match
+func (d *ServiceAPI) Get(c web.C, w http.ResponseWriter, r *http.Request) {
+	identifier, _ := url.QueryUnescape(c.URLParams["id"])
+	serviceModel, err := d.Store.Get(identifier)
+	if err != nil {
+		responseStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(serviceModel.Version))
+	responseJSON(w, serviceModel)
+}
+
 func (d *ServiceAPI) Create(w http.ResponseWriter, r *http.Request) {
 	serviceModel, err := extractServiceModel(r)
 
@@ -37,45 +51,103 @@ func (d *ServiceAPI) Create(w http.ResponseWriter, r *http.Request) {
 		responseError(w, err.Error())
 		return
 	}
+	if err := serviceModel.Validate(); err != nil {
+		responseError(w, err.Error())
+		return
+	}
 
-	_, err2 := service.Create(d.Zookeeper, d.Config.Bamboo.Zookeeper, serviceModel.Id, serviceModel.Acl)
-	if err2 != nil {
-		responseError(w, "Marathon ID might already exist")
+	err2 := d.Store.Create(serviceModel)
+	if err2 == service.ErrExists {
+		http.Error(w, "Marathon ID already exists", http.StatusConflict)
+		return
+	} else if err2 != nil {
+		responseError(w, err2.Error())
 		return
 	}
 
 	responseJSON(w, serviceModel)
 }
 
+/*
+	Updates the service as long as it still has the version of the If-Match
+	header. Responds with the service and its new version, also as ETag.
+*/
 func (d *ServiceAPI) Put(c web.C, w http.ResponseWriter, r *http.Request) {
 	identifier, _ := url.QueryUnescape(c.URLParams["id"])
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
 	serviceModel, err := extractServiceModel(r)
 	if err != nil {
 		responseError(w, err.Error())
 		return
 	}
+	serviceModel.Id = identifier
+	serviceModel.Version = version
+	if err := serviceModel.Validate(); err != nil {
+		responseError(w, err.Error())
+		return
+	}
 
-	_, err1 := service.Put(d.Zookeeper, d.Config.Bamboo.Zookeeper, identifier, serviceModel.Acl)
-	if err1 != nil {
-		responseError(w, err1.Error())
+	serviceModel.Version, err = d.Store.Update(serviceModel)
+	if err != nil {
+		responseStoreError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", strconv.Quote(serviceModel.Version))
 	responseJSON(w, serviceModel)
 }
 
 
+// Deletes the service as long as it still has the version of the If-Match header
 func (d *ServiceAPI) Delete(c web.C, w http.ResponseWriter, r *http.Request) {
 	identifier, _ := url.QueryUnescape(c.URLParams["id"])
-	err := service.Delete(d.Zookeeper, d.Config.Bamboo.Zookeeper, identifier)
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	err := d.Store.Delete(identifier, version)
 	if err != nil {
-		responseError(w, err.Error())
+		responseStoreError(w, err)
 		return
 	}
 
 	responseJSON(w, new(map[string]string))
 }
 
+/*
+	Returns the version of the If-Match header, the ETag of the service.
+	"*" matches any version. Responds with 428 when the header is missing.
+*/
+func ifMatch(w http.ResponseWriter, r *http.Request) (string, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		http.Error(w, "If-Match header required, with the ETag of the service or *", http.StatusPreconditionRequired)
+		return "", false
+	}
+	if header == "*" {
+		return service.AnyVersion, true
+	}
+	header = strings.TrimPrefix(header, "W/")
+	if version, err := strconv.Unquote(header); err == nil {
+		return version, true
+	}
+	return header, true
+}
+
+func responseStoreError(w http.ResponseWriter, err error) {
+	switch err {
+	case service.ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case service.ErrConflict:
+		http.Error(w, "Service was changed meanwhile, get it again for its current version", http.StatusConflict)
+	default:
+		responseError(w, err.Error())
+	}
+}
+
 
 func extractServiceModel(r *http.Request) (service.Service, error) {
 	var serviceModel service.Service
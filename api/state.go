@@ -9,14 +9,23 @@ import (
 
 	"github.com/seomoz/roger-bamboo/configuration"
 	"github.com/seomoz/roger-bamboo/services/haproxy"
+	"github.com/seomoz/roger-bamboo/services/service"
+	"github.com/seomoz/roger-bamboo/services/source"
 )
 
 type StateAPI struct {
 	Config    *configuration.Configuration
+	Store     service.Store
 	Zookeeper *zk.Conn
+	Source    source.Source
 }
 
 func (state *StateAPI) Get(w http.ResponseWriter, r *http.Request) {
-	payload, _ := json.Marshal(haproxy.GetTemplateData(state.Config, state.Zookeeper))
+	templateData, err := haproxy.GetTemplateData(state.Config, state.Store, state.Zookeeper, state.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	payload, _ := json.Marshal(templateData)
 	io.WriteString(w, string(payload))
 }
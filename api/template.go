@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/samuel/go-zookeeper/zk"
+
+	conf "github.com/seomoz/roger-bamboo/configuration"
+	eb "github.com/seomoz/roger-bamboo/services/event_bus"
+	"github.com/seomoz/roger-bamboo/services/haproxy"
+	"github.com/seomoz/roger-bamboo/services/service"
+	"github.com/seomoz/roger-bamboo/services/source"
+	"github.com/seomoz/roger-bamboo/services/template"
+)
+
+type TemplateAPI struct {
+	Config    *conf.Configuration
+	Store     service.Store
+	Zookeeper *zk.Conn
+	Source    source.Source
+}
+
+type TemplatePreviewRequest struct {
+	Template string
+	// Runs HAProxy.ValidateCommand against the rendered configuration
+	Validate bool
+}
+
+type TemplatePreview struct {
+	Output     string
+	Error      *template.RenderError `json:",omitempty"`
+	Validation *TemplateValidation   `json:",omitempty"`
+}
+
+type TemplateValidation struct {
+	Success bool
+	Output  string
+}
+
+/*
+	Renders the posted template against the live template data. The body is
+	either a TemplatePreviewRequest JSON document, or the template text
+	itself along with an optional validate query parameter. Nothing is
+	written to HAProxy.OutputPath.
+*/
+func (t *TemplateAPI) Preview(w http.ResponseWriter, r *http.Request) {
+	request, err := extractTemplatePreviewRequest(r)
+	if err != nil {
+		responseError(w, err.Error())
+		return
+	}
+
+	templateData, err := haproxy.GetTemplateData(t.Config, t.Store, t.Zookeeper, t.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var preview TemplatePreview
+	preview.Output, err = template.RenderTemplate("preview", request.Template, templateData)
+	if err != nil {
+		described := template.DescribeError(err)
+		preview.Error = &described
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		responseJSON(w, preview)
+		return
+	}
+
+	if request.Validate {
+		output, err := eb.ValidateConfig(t.Config.HAProxy, preview.Output)
+		preview.Validation = &TemplateValidation{Success: err == nil, Output: output}
+		if err != nil && output == "" {
+			preview.Validation.Output = err.Error()
+		}
+	}
+
+	responseJSON(w, preview)
+}
+
+func extractTemplatePreviewRequest(r *http.Request) (TemplatePreviewRequest, error) {
+	var request TemplatePreviewRequest
+	payload, _ := ioutil.ReadAll(r.Body)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return request, errors.New("Unable to decode JSON request")
+		}
+	} else {
+		request.Template = string(payload)
+		request.Validate, _ = strconv.ParseBool(r.URL.Query().Get("validate"))
+	}
+
+	if request.Template == "" {
+		return request, errors.New("A template is required")
+	}
+	return request, nil
+}
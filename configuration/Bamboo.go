@@ -1,9 +1,41 @@
 package configuration
 
+import (
+	"strings"
+)
+
 type Bamboo struct {
 	// Service host
 	Endpoint string
 
-	// Routing configuration storage
+	// Storage of the routing configuration: "zookeeper", "etcd" or
+	// "consul". Defaults to "zookeeper".
+	Store string
+
+	// Routing configuration storages, only the one selected by Store is
+	// used
 	Zookeeper Zookeeper
+	Etcd      Etcd
+	Consul    Consul
+
+	// Zookeeper path of the external TCP port reservations, on the same
+	// ensemble as Zookeeper. It must not be below Zookeeper.Path.
+	// Reservations are disabled when empty.
+	PortReservationPath string
+}
+
+// Returns the selected storage of the routing configuration
+func (b Bamboo) StoreType() string {
+	if b.Store == "" {
+		return StoreZookeeper
+	}
+	return strings.ToLower(b.Store)
+}
+
+/*
+	Whether Zookeeper is used, storing either the routing configuration or
+	the port reservations
+*/
+func (b Bamboo) UsesZookeeper() bool {
+	return b.StoreType() == StoreZookeeper || b.PortReservationPath != ""
 }
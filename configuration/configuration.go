@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 )
 
 var logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
@@ -24,6 +26,9 @@ type Configuration struct {
 
 	// StatsD configuration
 	StatsD StatsD
+
+	// Sources of the apps, combined in this order. Defaults to Marathon.
+	Sources []Source
 }
 
 /*
@@ -44,21 +49,83 @@ func FromFile(filePath string) (Configuration, error) {
 	conf := &Configuration{}
 	err := conf.FromFile(filePath)
 	setValueFromEnv(&conf.Marathon.Endpoint, "MARATHON_ENDPOINT")
+	setBoolValueFromEnv(&conf.Marathon.UseEventStream, "MARATHON_USE_EVENT_STREAM")
+	setValueFromEnv(&conf.Marathon.TaskFilter, "MARATHON_TASK_FILTER")
+	setBoolValueFromEnv(&conf.Marathon.EmbedDeployments, "MARATHON_EMBED_DEPLOYMENTS")
+	setValueFromEnv(&conf.Marathon.User, "MARATHON_USER")
+	setValueFromEnv(&conf.Marathon.Password, "MARATHON_PASSWORD")
+	setValueFromEnv(&conf.Marathon.Token, "MARATHON_TOKEN")
+	setValueFromEnv(&conf.Marathon.TokenFile, "MARATHON_TOKEN_FILE")
+	setValueFromEnv(&conf.Marathon.TokenType, "MARATHON_TOKEN_TYPE")
+	setValueFromEnv(&conf.Marathon.ClientCert, "MARATHON_CLIENT_CERT")
+	setValueFromEnv(&conf.Marathon.ClientKey, "MARATHON_CLIENT_KEY")
+	setValueFromEnv(&conf.Marathon.CACert, "MARATHON_CA_CERT")
+	setBoolValueFromEnv(&conf.Marathon.InsecureSkipVerify, "MARATHON_INSECURE_SKIP_VERIFY")
+	setValueFromEnv(&conf.Marathon.Mesos.Endpoint, "MESOS_ENDPOINT")
+	setValueFromEnv(&conf.Marathon.Mesos.FrameworkName, "MESOS_FRAMEWORK_NAME")
 
 	setValueFromEnv(&conf.Bamboo.Endpoint, "BAMBOO_ENDPOINT")
+	setValueFromEnv(&conf.Bamboo.Store, "BAMBOO_STORE")
 	setValueFromEnv(&conf.Bamboo.Zookeeper.Host, "BAMBOO_ZK_HOST")
 	setValueFromEnv(&conf.Bamboo.Zookeeper.Path, "BAMBOO_ZK_PATH")
+	setValueFromEnv(&conf.Bamboo.Zookeeper.User, "BAMBOO_ZK_USER")
+	setValueFromEnv(&conf.Bamboo.Zookeeper.Password, "BAMBOO_ZK_PASSWORD")
+	setListValueFromEnv(&conf.Bamboo.Zookeeper.ACL, "BAMBOO_ZK_ACL")
+	setValueFromEnv(&conf.Bamboo.Etcd.Endpoint, "BAMBOO_ETCD_ENDPOINT")
+	setValueFromEnv(&conf.Bamboo.Etcd.Prefix, "BAMBOO_ETCD_PREFIX")
+	setValueFromEnv(&conf.Bamboo.Etcd.User, "BAMBOO_ETCD_USER")
+	setValueFromEnv(&conf.Bamboo.Etcd.Password, "BAMBOO_ETCD_PASSWORD")
+	setValueFromEnv(&conf.Bamboo.Consul.Endpoint, "BAMBOO_CONSUL_ENDPOINT")
+	setValueFromEnv(&conf.Bamboo.Consul.Prefix, "BAMBOO_CONSUL_PREFIX")
+	setValueFromEnv(&conf.Bamboo.Consul.Token, "BAMBOO_CONSUL_TOKEN")
+	setValueFromEnv(&conf.Bamboo.Consul.Datacenter, "BAMBOO_CONSUL_DATACENTER")
+	setValueFromEnv(&conf.Bamboo.PortReservationPath, "BAMBOO_PORT_RESERVATION_PATH")
 
 	setValueFromEnv(&conf.HAProxy.TemplatePath, "HAPROXY_TEMPLATE_PATH")
 	setValueFromEnv(&conf.HAProxy.OutputPath, "HAPROXY_OUTPUT_PATH")
 	setValueFromEnv(&conf.HAProxy.ReloadCommand, "HAPROXY_RELOAD_CMD")
+	setValueFromEnv(&conf.HAProxy.ValidateCommand, "HAPROXY_VALIDATE_CMD")
+	setValueFromEnv(&conf.HAProxy.StatsSocket, "HAPROXY_STATS_SOCKET")
+	setValueFromEnv(&conf.HAProxy.ReloadHistoryPath, "HAPROXY_RELOAD_HISTORY_PATH")
+
+	setSourcesFromEnv(&conf.Sources, "BAMBOO_SOURCES")
 	return *conf, err
 }
 
+// Env vars whose value is not logged
+var secretEnvVars = map[string]bool{"MARATHON_PASSWORD": true, "MARATHON_TOKEN": true,
+	"BAMBOO_ZK_PASSWORD": true, "BAMBOO_ETCD_PASSWORD": true, "BAMBOO_CONSUL_TOKEN": true}
+
 func setValueFromEnv(field *string, envVar string) {
 	env := os.Getenv(envVar)
 	if len(env) > 0 {
-		log.Printf("Using environment override %s=%s", envVar, env)
+		if secretEnvVars[envVar] {
+			log.Printf("Using environment override %s", envVar)
+		} else {
+			log.Printf("Using environment override %s=%s", envVar, env)
+		}
 		*field = env
 	}
 }
+
+func setBoolValueFromEnv(field *bool, envVar string) {
+	env := os.Getenv(envVar)
+	if len(env) > 0 {
+		value, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Printf("Ignoring invalid environment override %s=%s", envVar, env)
+			return
+		}
+		log.Printf("Using environment override %s=%t", envVar, value)
+		*field = value
+	}
+}
+
+// Sets a list from a comma separated env var
+func setListValueFromEnv(field *[]string, envVar string) {
+	env := os.Getenv(envVar)
+	if len(env) > 0 {
+		log.Printf("Using environment override %s=%s", envVar, env)
+		*field = strings.Split(env, ",")
+	}
+}
@@ -1,11 +1,39 @@
 package configuration
 
 import (
-//	"text/template"
+	"strings"
 )
 
 type HAProxy struct {
 	TemplatePath  string
 	OutputPath    string
 	ReloadCommand string
+
+	// Command checking a rendered configuration before it replaces
+	// OutputPath, e.g. "haproxy -c -f {{.}}". The {{.}} placeholder is
+	// replaced by the path of the candidate configuration file.
+	// Validation is skipped when empty.
+	ValidateCommand string
+
+	// Path of the HAProxy stats socket, declared with "level admin".
+	// When set, changes which only move servers or toggle their state
+	// are applied through the runtime API instead of a reload.
+	StatsSocket string
+
+	// Number of reloads kept in the history, 50 when not set
+	ReloadHistorySize int
+	// Directory the reload history is persisted to, kept in memory
+	// only when empty
+	ReloadHistoryPath string
+}
+
+// Path of the copy of the last configuration HAProxy was successfully
+// reloaded with
+func (h HAProxy) LastGoodPath() string {
+	return h.OutputPath + ".last-good"
+}
+
+// Returns the validation command for the configuration file at path
+func (h HAProxy) ValidateCommandFor(path string) string {
+	return strings.Replace(h.ValidateCommand, "{{.}}", path, -1)
 }
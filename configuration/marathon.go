@@ -2,6 +2,13 @@ package configuration
 
 import (
 	"strings"
+	"time"
+)
+
+// Defaults of the Marathon request settings
+const (
+	defaultRequestTimeout   = 10
+	defaultEndpointCooldown = 30
 )
 
 /*
@@ -10,8 +17,67 @@ import (
 type Marathon struct {
 	// comma separated marathon http endpoints including port number
 	Endpoint string
+
+	// Consume the /v2/events stream instead of registering an HTTP
+	// callback with Marathon's event subscriptions
+	UseEventStream bool
+
+	// Default policy deciding which tasks are load balanced: "healthy",
+	// "running" or "all". Apps may override it with the TASK_FILTER env
+	// var or the bamboo.task.filter label. Defaults to "running".
+	TaskFilter string
+
+	// Also fetch the deployments in progress of every app, see
+	// App.Deployments
+	EmbedDeployments bool
+
+	// Timeout of a request to Marathon in seconds. Defaults to 10.
+	RequestTimeout int64
+
+	// Seconds an endpoint failing a request is skipped for, unless no
+	// other endpoint is available. Defaults to 30.
+	EndpointCooldown int64
+
+	// HTTP basic authentication credentials
+	User     string
+	Password string
+
+	// Token sent in the Authorization header, e.g. a DC/OS ACS token.
+	// TokenFile is read on every request, so that the token may be
+	// rotated without a restart. The token is sent as "token=<token>"
+	// unless TokenType is "Bearer".
+	Token     string
+	TokenFile string
+	TokenType string
+
+	// PEM encoded client certificate and key presented to Marathon
+	ClientCert string
+	ClientKey  string
+	// PEM encoded CA bundle used to verify Marathon's certificate,
+	// instead of the system roots
+	CACert string
+	// Skips the verification of Marathon's certificate
+	InsecureSkipVerify bool
+
+	// Mesos masters the tasks are read from while Marathon is
+	// unavailable, see services/mesos
+	Mesos Mesos
 }
 
 func (m Marathon) Endpoints() []string {
 	return strings.Split(m.Endpoint, ",")
 }
+
+func (m Marathon) Timeout() time.Duration {
+	if m.RequestTimeout <= 0 {
+		return defaultRequestTimeout * time.Second
+	}
+	return time.Duration(m.RequestTimeout) * time.Second
+}
+
+func (m Marathon) Cooldown() time.Duration {
+	if m.EndpointCooldown <= 0 {
+		return defaultEndpointCooldown * time.Second
+	}
+	return time.Duration(m.EndpointCooldown) * time.Second
+}
@@ -0,0 +1,32 @@
+package configuration
+
+import (
+	"strings"
+)
+
+/*
+	Mesos masters of a Marathon cluster, whose state is read while every
+	Marathon endpoint is unavailable
+*/
+type Mesos struct {
+	// comma separated mesos master http endpoints including port number
+	Endpoint string
+
+	// Name of the Marathon framework in Mesos. Defaults to "marathon".
+	FrameworkName string
+}
+
+func (m Mesos) Endpoints() []string {
+	return strings.Split(m.Endpoint, ",")
+}
+
+func (m Mesos) Enabled() bool {
+	return m.Endpoint != ""
+}
+
+func (m Mesos) Framework() string {
+	if m.FrameworkName == "" {
+		return "marathon"
+	}
+	return m.FrameworkName
+}
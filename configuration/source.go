@@ -0,0 +1,101 @@
+package configuration
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+/*
+	A source of the apps to load balance. Without any configured source,
+	Marathon is the only one.
+*/
+type Source struct {
+	// Kind of source: "marathon" or "file"
+	Type string
+	// Identifies the source in logs, StatsD and reload triggers. Defaults
+	// to the type.
+	Name string
+	// YAML or JSON file, or directory of such files, read by "file" sources
+	Path string
+
+	// Settings of the cluster of a "marathon" source, with its own
+	// endpoints and credentials. Defaults to the top level Marathon.
+	Marathon *Marathon
+
+	// Prepended to the ids of the apps of the source, e.g. "/east", so
+	// that apps with the same id in several sources do not collide
+	IdPrefix string
+	// Only prefixes the EscapedId naming the backends, keeping the Id and
+	// hence the path the apps are routed at
+	PrefixEscapedIdOnly bool
+}
+
+/*
+	Returns the configured sources with their type in lower case and their
+	name defaulted, or a single Marathon source when none is configured.
+*/
+func (config Configuration) SourceList() []Source {
+	if len(config.Sources) == 0 {
+		return []Source{{Type: "marathon", Name: "marathon"}}
+	}
+	sources := []Source{}
+	for _, source := range config.Sources {
+		source.Type = strings.ToLower(source.Type)
+		if source.Type == "" {
+			source.Type = "marathon"
+		}
+		if source.Name == "" {
+			source.Name = source.Type
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+/*
+	Returns the settings of every Marathon cluster, in the order of the
+	sources.
+*/
+func (config Configuration) MarathonClusters() []Marathon {
+	clusters := []Marathon{}
+	for _, source := range config.SourceList() {
+		if source.Type == "marathon" {
+			clusters = append(clusters, config.MarathonOf(source))
+		}
+	}
+	return clusters
+}
+
+// Returns the Marathon settings of a source
+func (config Configuration) MarathonOf(source Source) Marathon {
+	if source.Marathon != nil {
+		return *source.Marathon
+	}
+	return config.Marathon
+}
+
+/*
+	Replaces the sources with a comma separated list of source types, each
+	optionally followed by a colon and a path, e.g. "marathon,file:/apps".
+*/
+func setSourcesFromEnv(field *[]Source, envVar string) {
+	env := os.Getenv(envVar)
+	if len(env) == 0 {
+		return
+	}
+	log.Printf("Using environment override %s=%s", envVar, env)
+	sources := []Source{}
+	for _, item := range strings.Split(env, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		source := Source{Type: parts[0]}
+		if len(parts) == 2 {
+			source.Path = parts[1]
+		}
+		sources = append(sources, source)
+	}
+	*field = sources
+}
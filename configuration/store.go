@@ -0,0 +1,72 @@
+package configuration
+
+import (
+	"strings"
+	"time"
+)
+
+// Storages of the service routing rules
+const (
+	StoreZookeeper = "zookeeper"
+	StoreEtcd      = "etcd"
+	StoreConsul    = "consul"
+)
+
+// Timeout of a request to etcd or Consul
+const storeRequestTimeout = 10 * time.Second
+
+/*
+	etcd v3 storage of the routing rules, through the JSON gateway of
+	etcd 3.4 and later
+*/
+type Etcd struct {
+	// comma separated etcd http endpoints including port number
+	Endpoint string
+	// Key prefix of the rules. Defaults to "/bamboo/services/".
+	Prefix string
+
+	// Credentials of an etcd user, when authentication is enabled
+	User     string
+	Password string
+}
+
+func (e Etcd) Endpoints() []string {
+	return strings.Split(e.Endpoint, ",")
+}
+
+func (e Etcd) KeyPrefix() string {
+	if e.Prefix == "" {
+		return "/bamboo/services/"
+	}
+	return strings.TrimSuffix(e.Prefix, "/") + "/"
+}
+
+func (e Etcd) Timeout() time.Duration {
+	return storeRequestTimeout
+}
+
+/*
+	Consul KV storage of the routing rules
+*/
+type Consul struct {
+	// Consul agent http endpoint including port number
+	Endpoint string
+	// Key prefix of the rules. Defaults to "bamboo/services/".
+	Prefix string
+
+	// ACL token sent with the requests
+	Token string
+	// Datacenter of the keys. Defaults to the datacenter of the agent.
+	Datacenter string
+}
+
+func (c Consul) KeyPrefix() string {
+	if c.Prefix == "" {
+		return "bamboo/services/"
+	}
+	return strings.Trim(c.Prefix, "/") + "/"
+}
+
+func (c Consul) Timeout() time.Duration {
+	return storeRequestTimeout
+}
@@ -17,13 +17,26 @@ type Zookeeper struct {
 	// Delay n seconds to report change event
 	ReportingDelay int64
 
-	// TODO: authentication parameters for zookeeper
+	// Credentials of a digest user, added to every connection. SASL
+	// authentication is not supported.
+	User     string
+	Password string
+	// ACL of the nodes Bamboo creates, as "scheme:id:permissions" entries,
+	// e.g. "digest:bamboo:<base64 sha1>:cdrwa" or "world:anyone:r".
+	// Defaults to all permissions for the digest user when set, for anyone
+	// otherwise.
+	ACL []string
 }
 
 func (zk Zookeeper) Delay() time.Duration {
 	return time.Duration(zk.ReportingDelay) * time.Second
 }
 
+// The credentials added with the digest scheme, "user:password"
+func (zk Zookeeper) Credentials() string {
+	return zk.User + ":" + zk.Password
+}
+
 func (zk Zookeeper) ConnectionString() []string {
 	return strings.Split(zk.Host, ",")
 }
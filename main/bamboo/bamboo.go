@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -20,6 +21,10 @@ import (
 	"github.com/seomoz/roger-bamboo/configuration"
 	"github.com/seomoz/roger-bamboo/qzk"
 	"github.com/seomoz/roger-bamboo/services/event_bus"
+	"github.com/seomoz/roger-bamboo/services/golden"
+	"github.com/seomoz/roger-bamboo/services/marathon"
+	"github.com/seomoz/roger-bamboo/services/service"
+	"github.com/seomoz/roger-bamboo/services/source"
 )
 
 /*
@@ -34,8 +39,12 @@ func init() {
 }
 
 func main() {
-	log.Println("Starting binary..")
 	flag.Parse()
+	if flag.Arg(0) == "template" {
+		os.Exit(templateCommand(flag.Args()[1:]))
+	}
+
+	log.Println("Starting binary..")
 	configureLog()
 
 	// Load configuration
@@ -44,6 +53,12 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Create the sources of the apps
+	src, err := source.New(&conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	eventBus := event_bus.New()
 
 	// Wait for died children to avoid zombies
@@ -63,12 +78,24 @@ func main() {
 	// Create StatsD client
 	conf.StatsD.CreateClient()
 
-	// Create Zookeeper connection
-	zkConn := listenToZookeeper(conf, eventBus)
+	if err := event_bus.LoadReloadHistory(conf.HAProxy); err != nil {
+		log.Printf("Unable to load reload history: %s\n", err)
+	}
+
+	// Create Zookeeper connection, when Zookeeper is used
+	zkConn := connectToZookeeper(conf)
+
+	// Create the store of the routing rules
+	store, err := service.NewStore(&conf, zkConn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	listenToStore(store, eventBus)
 
 	// Register handlers
-	handlers := event_bus.Handlers{Conf: &conf, Zookeeper: zkConn}
+	handlers := event_bus.Handlers{Conf: &conf, Store: store, Zookeeper: zkConn, Source: src}
 	eventBus.Register(handlers.MarathonEventHandler)
+	eventBus.Register(handlers.SourceEventHandler)
 	eventBus.Register(handlers.ServiceEventHandler)
 	log.Println("Registered handlers")
 
@@ -79,18 +106,21 @@ func main() {
 			<-ticker
 			// Simulate a service event to write out the HAproxy config initially
 			log.Println("Simulating service event....")
-			eventBus.Publish(event_bus.ServiceEvent{EventType: "change"})
+			eventBus.Publish(event_bus.ServiceEvent{EventType: event_bus.TriggerPeriodic})
 		}
 	}()
 
 	// Start server
-	initServer(&conf, zkConn, eventBus)
+	initServer(&conf, store, zkConn, src, eventBus)
 }
 
-func initServer(conf *configuration.Configuration, conn *zk.Conn, eventBus *event_bus.EventBus) {
+func initServer(conf *configuration.Configuration, store service.Store, conn *zk.Conn, src source.Source, eventBus *event_bus.EventBus) {
 	log.Println("in initServer")
-	stateAPI := api.StateAPI{Config: conf, Zookeeper: conn}
-	serviceAPI := api.ServiceAPI{Config: conf, Zookeeper: conn}
+	stateAPI := api.StateAPI{Config: conf, Store: store, Zookeeper: conn, Source: src}
+	serviceAPI := api.ServiceAPI{Config: conf, Store: store}
+	portsAPI := api.PortsAPI{Config: conf, Store: store, Zookeeper: conn, Source: src}
+	templateAPI := api.TemplateAPI{Config: conf, Store: store, Zookeeper: conn, Source: src}
+	marathonAPI := api.MarathonAPI{Config: conf}
 	eventSubAPI := api.EventSubscriptionAPI{Conf: conf, EventBus: eventBus}
 
 	log.Println("in initServer 2")
@@ -106,22 +136,49 @@ func initServer(conf *configuration.Configuration, conn *zk.Conn, eventBus *even
 	// Currently used ports
 	goji.Get("/usedports", event_bus.GetUsedPorts)
 
+	// Reload history
+	goji.Get("/api/reloads", event_bus.GetReloads)
+	goji.Get("/api/reloads/:id/config", event_bus.GetReloadConfig)
+	goji.Get("/api/reloads/:id/diff", event_bus.GetReloadDiff)
+
 	// State API
 	goji.Get("/api/state", stateAPI.Get)
 
+	// Invalid app settings
+	goji.Get("/api/errors", event_bus.GetAppErrors)
+
+	// External TCP ports and their reservations
+	goji.Get("/api/ports", portsAPI.Get)
+	goji.Post("/api/ports/reservations", portsAPI.Reserve)
+	goji.Delete("/api/ports/reservations/:port", portsAPI.Release)
+
+	// Renders a template against the live data, without applying it
+	goji.Post("/api/template/preview", templateAPI.Preview)
+
 	// Service API
 	goji.Get("/api/services", serviceAPI.All)
 	goji.Post("/api/services", serviceAPI.Create)
+	goji.Get("/api/services/:id", serviceAPI.Get)
 	goji.Put("/api/services/:id", serviceAPI.Put)
 	goji.Delete("/api/services/:id", serviceAPI.Delete)
 	goji.Post("/api/marathon/event_callback", eventSubAPI.Callback)
 
+	// Health of the Marathon endpoints
+	goji.Get("/api/marathon/endpoints", marathonAPI.Endpoints)
+
 	log.Println("in initServer 3")
 	// Static pages
 	goji.Get("/*", http.FileServer(http.Dir(path.Join(executableFolder(), "webapp"))))
 
 	log.Println("in initServer 4")
-	registerMarathonEvent(conf)
+	if changes := src.Changes(); changes != nil {
+		go listenToSourceChanges(changes, eventBus)
+	}
+	for _, maraconf := range conf.MarathonClusters() {
+		if !maraconf.UseEventStream {
+			registerMarathonEvent(conf, maraconf)
+		}
+	}
 
 	goji.Serve()
 }
@@ -135,43 +192,125 @@ func executableFolder() string {
 	return folderPath
 }
 
-func registerMarathonEvent(conf *configuration.Configuration) {
+func registerMarathonEvent(conf *configuration.Configuration, maraconf configuration.Marathon) {
 	log.Println("in registerMarathonEvent 1")
-	client := &http.Client{}
+	client, err := marathon.NewClient(maraconf, maraconf.Timeout())
+	if err != nil {
+		log.Printf("Unable to register Marathon event subscription: %s\n", err)
+		return
+	}
 	// it's safe to register with multiple marathon nodes
-	for _, marathon := range conf.Marathon.Endpoints() {
-		url := marathon + "/v2/eventSubscriptions?callbackUrl=" + conf.Bamboo.Endpoint + "/api/marathon/event_callback"
+	for _, endpoint := range maraconf.Endpoints() {
+		url := endpoint + "/v2/eventSubscriptions?callbackUrl=" + conf.Bamboo.Endpoint + "/api/marathon/event_callback"
 		req, _ := http.NewRequest("POST", url, nil)
 		req.Header.Add("Content-Type", "application/json")
 		log.Println("calling " + url)
-		client.Do(req)
+		response, err := client.Do(req)
+		if err != nil {
+			log.Printf("Unable to register event subscription with %s: %s\n", endpoint, err)
+			continue
+		}
+		response.Body.Close()
 	}
 	log.Println("in registerMarathonEvent 2")
 }
 
-func createAndListen(conf configuration.Zookeeper) (chan zk.Event, *zk.Conn) {
-	conn, _, err := zk.Connect(conf.ConnectionString(), time.Second*10)
+func listenToSourceChanges(changes <-chan source.Change, eventBus *event_bus.EventBus) {
+	for change := range changes {
+		eventBus.Publish(event_bus.SourceEvent{Source: change.Source, Reason: change.Reason})
+	}
+}
 
+/*
+	Connects to Zookeeper, when it stores either the routing rules or the
+	port reservations. Returns nil otherwise.
+*/
+func connectToZookeeper(conf configuration.Configuration) *zk.Conn {
+	if !conf.Bamboo.UsesZookeeper() {
+		return nil
+	}
+	conn, err := qzk.Connect(conf.Bamboo.Zookeeper, time.Second*10)
 	if err != nil {
 		log.Panic(err)
 	}
-
-	ch, _ := qzk.ListenToConn(conn, conf.Path, true, conf.Delay())
-	return ch, conn
+	return conn
 }
 
-func listenToZookeeper(conf configuration.Configuration, eventBus *event_bus.EventBus) *zk.Conn {
-	serviceCh, serviceConn := createAndListen(conf.Bamboo.Zookeeper)
+func listenToStore(store service.Store, eventBus *event_bus.EventBus) {
+	events, err := store.Watch(make(chan bool))
+	if err != nil {
+		log.Printf("Unable to watch the services, relying on periodic updates: %s\n", err)
+		return
+	}
 
 	go func() {
-		for {
-			select {
-			case _ = <-serviceCh:
-				eventBus.Publish(event_bus.ServiceEvent{EventType: "change"})
-			}
+		for event := range events {
+			eventBus.Publish(event_bus.ServiceEvent{EventType: event.EventType})
 		}
 	}()
-	return serviceConn
+}
+
+/*
+	Runs the template subcommands:
+
+		bamboo template test [-template path] [-update] <fixtures dir>
+
+	renders the template against every fixture of the directory, see the
+	golden package, and reports the ones not matching their expected config.
+	The template defaults to HAProxy.TemplatePath of the configuration.
+*/
+func templateCommand(args []string) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintln(os.Stderr, "Usage: bamboo template test [-template path] [-update] <fixtures dir>")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("template test", flag.ExitOnError)
+	templatePath := flags.String("template", "", "Path of the template. Defaults to HAProxy.TemplatePath of the configuration")
+	update := flags.Bool("update", false, "Rewrites the expected configs with the rendered ones")
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: bamboo template test [-template path] [-update] <fixtures dir>")
+		return 2
+	}
+
+	if *templatePath == "" {
+		conf, err := configuration.FromFile(configFilePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		*templatePath = conf.HAProxy.TemplatePath
+	}
+
+	results, err := golden.Run(*templatePath, flags.Arg(0), *update)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("FAIL %s: %s\n", result.Name, result.Err)
+		case result.Diff != "":
+			fmt.Printf("FAIL %s\n%s", result.Name, result.Diff)
+		case *update:
+			fmt.Printf("updated %s\n", result.Name)
+		default:
+			fmt.Printf("ok   %s\n", result.Name)
+		}
+		if !result.Passed() {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d fixtures failed\n", failed, len(results))
+		return 1
+	}
+	return 0
 }
 
 func configureLog() {
@@ -0,0 +1,117 @@
+package qzk
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+
+	c "github.com/seomoz/roger-bamboo/configuration"
+)
+
+const digestScheme = "digest"
+
+var permissions = map[rune]int32{
+	'c': zk.PermCreate,
+	'd': zk.PermDelete,
+	'r': zk.PermRead,
+	'w': zk.PermWrite,
+	'a': zk.PermAdmin,
+}
+
+// The authentication requests of a connection, implemented by *zk.Conn
+type authConn interface {
+	AddAuth(scheme string, auth []byte) error
+	Close()
+}
+
+/*
+	Connects to Zookeeper and authenticates the connection as the configured
+	digest user. The server forgets the credentials when the connection
+	drops, so they are added again every time the session is reestablished.
+
+	SASL is not supported: the vendored client has no SASL handshake, so
+	digest is the only scheme Bamboo can authenticate with.
+*/
+func Connect(config c.Zookeeper, recvTimeout time.Duration) (*zk.Conn, error) {
+	if _, err := ACL(config); err != nil {
+		return nil, err
+	}
+
+	conn, events, err := zk.Connect(config.ConnectionString(), recvTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := authenticate(conn, events, config); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Adds the credentials of the digest user, if any, to conn and to every
+// session it reestablishes. conn is closed when the first attempt fails.
+func authenticate(conn authConn, events <-chan zk.Event, config c.Zookeeper) error {
+	if config.User == "" {
+		return nil
+	}
+
+	// Queued ahead of any other request, sent once the session is established
+	if err := conn.AddAuth(digestScheme, []byte(config.Credentials())); err != nil {
+		conn.Close()
+		return fmt.Errorf("unable to authenticate to Zookeeper as %s: %s", config.User, err)
+	}
+	go reauthenticate(conn, events, config)
+	return nil
+}
+
+func reauthenticate(conn authConn, events <-chan zk.Event, config c.Zookeeper) {
+	for event := range events {
+		if event.State != zk.StateHasSession {
+			continue
+		}
+		if err := conn.AddAuth(digestScheme, []byte(config.Credentials())); err == zk.ErrClosing {
+			return
+		} else if err != nil {
+			logger.Printf("Unable to authenticate to Zookeeper as %s: %s", config.User, err)
+		}
+	}
+}
+
+/*
+	Returns the ACL of the nodes Bamboo creates, parsed from the
+	"scheme:id:permissions" entries of the configuration. The id may hold
+	colons, as digest and ip ids do.
+*/
+func ACL(config c.Zookeeper) ([]zk.ACL, error) {
+	if len(config.ACL) == 0 {
+		if config.User != "" {
+			return zk.DigestACL(zk.PermAll, config.User, config.Password), nil
+		}
+		return zk.WorldACL(zk.PermAll), nil
+	}
+
+	acl := []zk.ACL{}
+	for _, entry := range config.ACL {
+		entry = strings.TrimSpace(entry)
+		first := strings.Index(entry, ":")
+		last := strings.LastIndex(entry, ":")
+		if first <= 0 || last == first {
+			return nil, fmt.Errorf("invalid Zookeeper ACL %q, expected scheme:id:permissions", entry)
+		}
+
+		perms := int32(0)
+		for _, letter := range entry[last+1:] {
+			perm, ok := permissions[letter]
+			if !ok {
+				return nil, fmt.Errorf("invalid permission %q in Zookeeper ACL %q, expected some of cdrwa", letter, entry)
+			}
+			perms |= perm
+		}
+		if perms == 0 {
+			return nil, fmt.Errorf("no permission in Zookeeper ACL %q", entry)
+		}
+		acl = append(acl, zk.ACL{Perms: perms, Scheme: entry[:first], ID: entry[first+1 : last]})
+	}
+	return acl, nil
+}
@@ -0,0 +1,105 @@
+package qzk
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	. "github.com/smartystreets/goconvey/convey"
+
+	c "github.com/seomoz/roger-bamboo/configuration"
+)
+
+func TestACL(t *testing.T) {
+	Convey("#ACL", t, func() {
+		Convey("should give anyone all permissions by default", func() {
+			acl, err := ACL(c.Zookeeper{})
+			So(err, ShouldBeNil)
+			So(acl, ShouldResemble, zk.WorldACL(zk.PermAll))
+		})
+
+		Convey("should give the digest user all permissions when set", func() {
+			acl, err := ACL(c.Zookeeper{User: "bamboo", Password: "secret"})
+			So(err, ShouldBeNil)
+			So(acl, ShouldResemble, zk.DigestACL(zk.PermAll, "bamboo", "secret"))
+		})
+
+		Convey("should parse the configured entries, ids holding colons", func() {
+			acl, err := ACL(c.Zookeeper{User: "bamboo", ACL: []string{
+				"digest:bamboo:a2V5:cdrwa",
+				" world:anyone:r",
+				"ip:10.0.0.0/8:rw",
+			}})
+			So(err, ShouldBeNil)
+			So(acl, ShouldResemble, []zk.ACL{
+				{Perms: zk.PermAll, Scheme: "digest", ID: "bamboo:a2V5"},
+				{Perms: zk.PermRead, Scheme: "world", ID: "anyone"},
+				{Perms: zk.PermRead | zk.PermWrite, Scheme: "ip", ID: "10.0.0.0/8"},
+			})
+		})
+
+		Convey("should reject malformed entries", func() {
+			for _, entry := range []string{"world:anyone", ":anyone:r", "world:anyone:", "world:anyone:rx"} {
+				_, err := ACL(c.Zookeeper{ACL: []string{entry}})
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+// A connection recording the credentials added to it
+type fakeAuthConn struct {
+	auths  chan string
+	err    error
+	closed bool
+}
+
+func (f *fakeAuthConn) AddAuth(scheme string, auth []byte) error {
+	f.auths <- scheme + ":" + string(auth)
+	return f.err
+}
+
+func (f *fakeAuthConn) Close() {
+	f.closed = true
+}
+
+func TestAuthenticate(t *testing.T) {
+	Convey("#authenticate", t, func() {
+		conn := &fakeAuthConn{auths: make(chan string, 10)}
+		events := make(chan zk.Event)
+		defer close(events)
+		config := c.Zookeeper{User: "bamboo", Password: "secret"}
+
+		nextAuth := func() string {
+			select {
+			case auth := <-conn.auths:
+				return auth
+			case <-time.After(time.Second):
+				return ""
+			}
+		}
+
+		Convey("should add the credentials again on every new session", func() {
+			So(authenticate(conn, events, config), ShouldBeNil)
+			So(nextAuth(), ShouldEqual, "digest:bamboo:secret")
+
+			events <- zk.Event{Type: zk.EventSession, State: zk.StateDisconnected}
+			events <- zk.Event{Type: zk.EventSession, State: zk.StateConnected}
+			events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+			So(nextAuth(), ShouldEqual, "digest:bamboo:secret")
+			So(len(conn.auths), ShouldEqual, 0)
+		})
+
+		Convey("should not authenticate without a user", func() {
+			So(authenticate(conn, events, c.Zookeeper{}), ShouldBeNil)
+			So(len(conn.auths), ShouldEqual, 0)
+		})
+
+		Convey("should close the connection when authentication fails", func() {
+			conn.err = errors.New("closing")
+			So(authenticate(conn, events, config), ShouldNotBeNil)
+			So(conn.closed, ShouldBeTrue)
+		})
+	})
+}
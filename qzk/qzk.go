@@ -1,7 +1,6 @@
 package qzk
 
 import (
-	"fmt"
 	"log"
 	"os"
 	"time"
@@ -14,30 +13,6 @@ import (
 
 var logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
 
-func pollZooKeeper(conn *zk.Conn, path string, evts chan zk.Event, quit chan bool) {
-
-	children, _, err := conn.Children(path)
-	if err != nil {
-		fmt.Printf("%+v", err)
-		panic(err)
-	}
-
-	watcherControl := make([]chan<- bool, len(children)+2)
-	watcherControl[0] = sinkSelfEvents(conn, path, evts)
-	watcherControl[1] = sinkChildEvents(conn, path, evts)
-
-	for i, child := range children {
-		p := path + "/" + child
-		watcherControl[i+2] = sinkSelfEvents(conn, p, evts)
-	}
-
-	<-quit
-
-	for _, ch := range watcherControl {
-		ch <- true
-	}
-}
-
 func debounce(ch chan zk.Event, delay time.Duration) chan zk.Event {
 	debounced := make(chan zk.Event)
 	var t time.Timer
@@ -77,73 +52,25 @@ func delay(ch chan zk.Event, delay time.Duration) chan zk.Event {
 	return delayed
 }
 
-func sinkSelfEvents(conn *zk.Conn, path string, sink chan<- zk.Event) chan<- bool {
-	control := make(chan bool)
-	go func() {
-		_, _, selfCh, err := conn.GetW(path)
-		if err != nil {
-			logger.Panicf("failed to set listener on path: %s", err.Error())
-		}
-		for {
-			select {
-			case _ = <-control:
-				break
-			case ev := <-selfCh:
-				sink <- ev
-				_, _, selfCh, err = conn.GetW(path)
-				if err != nil {
-					logger.Printf("failed to set listener on path: %s\n", err.Error())
-				}
-			}
-		}
-	}()
-
-	return control
-}
-
-func sinkChildEvents(conn *zk.Conn, path string, sink chan<- zk.Event) chan<- bool {
-	control := make(chan bool)
-	go func() {
-		_, _, selfCh, err := conn.ChildrenW(path)
-		if err != nil {
-			logger.Panic("failed to set listener on path")
-		}
-		for {
-			select {
-			case _ = <-control:
-				break
-			case ev := <-selfCh:
-				sink <- ev
-				_, _, selfCh, err = conn.ChildrenW(path)
-				if err != nil {
-					logger.Panic("failed to set listener on path")
-				}
-			}
-		}
-	}()
-
-	return control
-}
-
 func ListenToZooKeeper(config c.Zookeeper, deb bool) (chan zk.Event, chan bool) {
-	c, _, err := zk.Connect(config.ConnectionString(), time.Second)
-
+	c, err := Connect(config, time.Second)
 	if err != nil {
 		panic(err)
 	}
 
-	return ListenToConn(c, config.Path, deb, config.Delay())
+	acl, _ := ACL(config)
+	return ListenToConn(c, config.Path, acl, deb, config.Delay())
 }
 
-func zkNodeCreateByPath(path string, c *zk.Conn) error {
-	return zkCreateNodes("", strings.Split(path, "/"), c)
+func zkNodeCreateByPath(path string, acl []zk.ACL, c *zk.Conn) error {
+	return zkCreateNodes("", strings.Split(path, "/"), acl, c)
 }
 
-func zkCreateNodes(path string, nodes []string, c *zk.Conn) error {
+func zkCreateNodes(path string, nodes []string, acl []zk.ACL, c *zk.Conn) error {
 	if len(nodes) > 0 {
 		// strings.Split will return empty-strings for leading split chars, lets skip over these.
 		if len(nodes[0]) == 0 {
-			return zkCreateNodes(path, nodes[1:], c)
+			return zkCreateNodes(path, nodes[1:], acl, c)
 		}
 		fqPath := path + "/" + nodes[0]
 		log.Printf("Creating path: %v", fqPath)
@@ -152,17 +79,17 @@ func zkCreateNodes(path string, nodes []string, c *zk.Conn) error {
 			return err
 		}
 		if !exists {
-			_, err := c.Create(fqPath, []byte{}, 0, zk.WorldACL(zk.PermAll))
+			_, err := c.Create(fqPath, []byte{}, 0, acl)
 			if err != nil {
 				return err
 			}
 		}
-		return zkCreateNodes(fqPath, nodes[1:], c)
+		return zkCreateNodes(fqPath, nodes[1:], acl, c)
 	}
 	return nil
 }
 
-func ListenToConn(c *zk.Conn, path string, deb bool, repDelay time.Duration) (chan zk.Event, chan bool) {
+func ListenToConn(c *zk.Conn, path string, acl []zk.ACL, deb bool, repDelay time.Duration) (chan zk.Event, chan bool) {
 	exists, _, err := c.Exists(path)
 
 	if err != nil {
@@ -170,7 +97,7 @@ func ListenToConn(c *zk.Conn, path string, deb bool, repDelay time.Duration) (ch
 	}
 	if !exists {
 		logger.Printf("Node '%v' does not exist in Zookeeper, creating...", path)
-		err := zkNodeCreateByPath(path, c)
+		err := zkNodeCreateByPath(path, acl, c)
 		if err != nil {
 			logger.Fatalf("Unable to create path '%v': %v", path, err)
 		}
@@ -180,7 +107,7 @@ func ListenToConn(c *zk.Conn, path string, deb bool, repDelay time.Duration) (ch
 	quit := make(chan bool)
 	evts := make(chan zk.Event)
 
-	go pollZooKeeper(c, path, evts, quit)
+	Watch(c, path, evts, quit)
 
 	if deb {
 		evts = debounce(evts, 100*time.Millisecond)
@@ -0,0 +1,222 @@
+package qzk
+
+import (
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// How long to wait before trying again to set watches which failed
+const defaultRetryDelay = time.Second
+
+// The ZooKeeper requests of a Watcher, implemented by *zk.Conn
+type Conn interface {
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error)
+}
+
+/*
+	Watcher reports the changes of a node, of its set of children and of
+	the data of each child. Children created after the watcher started are
+	watched as soon as they appear, and deleted ones are forgotten.
+
+	ZooKeeper watches fire once and are lost when the session expires. The
+	watcher sets them again after every event, and after a session expiry
+	sets them all again once the connection is back, reporting a
+	zk.EventSession event since changes may have been missed meanwhile.
+*/
+type Watcher struct {
+	conn       Conn
+	path       string
+	events     chan<- zk.Event
+	quit       <-chan bool
+	retryDelay time.Duration
+
+	// Events of the watches set, each watch firing once
+	fired chan watchEvent
+	// Whether watches are set on the data of the node, and on its children
+	nodeWatched     bool
+	childrenWatched bool
+	// Children of the node, mapped to whether a watch is set on their data
+	children map[string]bool
+	// Set when watches were lost, until they are all set again
+	lost bool
+}
+
+type watchEvent struct {
+	// Child the watch is set on, empty for the node itself
+	child    string
+	children bool
+	event    zk.Event
+}
+
+/*
+	Starts watching a node, sending its changes to events until quit
+	receives a value or is closed.
+*/
+func Watch(conn Conn, path string, events chan<- zk.Event, quit <-chan bool) *Watcher {
+	w := newWatcher(conn, path, events, quit)
+	go w.run()
+	return w
+}
+
+func newWatcher(conn Conn, path string, events chan<- zk.Event, quit <-chan bool) *Watcher {
+	return &Watcher{
+		conn:       conn,
+		path:       path,
+		events:     events,
+		quit:       quit,
+		retryDelay: defaultRetryDelay,
+		fired:      make(chan watchEvent),
+		children:   map[string]bool{},
+	}
+}
+
+func (w *Watcher) run() {
+	var retry <-chan time.Time
+	if !w.setWatches() {
+		retry = time.After(w.retryDelay)
+	}
+
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-retry:
+			retry = nil
+		case fired := <-w.fired:
+			if !w.handle(fired) {
+				return
+			}
+		}
+
+		if retry == nil && !w.setWatches() {
+			retry = time.After(w.retryDelay)
+		}
+	}
+}
+
+/*
+	Records that a watch fired and reports its event. Returns false when
+	the connection is closed.
+*/
+func (w *Watcher) handle(fired watchEvent) bool {
+	switch {
+	case fired.children:
+		w.childrenWatched = false
+	case fired.child == "":
+		w.nodeWatched = false
+	default:
+		if _, ok := w.children[fired.child]; ok {
+			w.children[fired.child] = false
+		}
+	}
+
+	if fired.event.Type == zk.EventNotWatching {
+		if fired.event.Err == zk.ErrClosing {
+			logger.Printf("Stopped watching %s, the connection is closed\n", w.path)
+			return false
+		}
+		if !w.lost {
+			logger.Printf("Lost the watches of %s: %v\n", w.path, fired.event.Err)
+		}
+		w.lost = true
+		return true
+	}
+	return w.send(fired.event)
+}
+
+func (w *Watcher) send(event zk.Event) bool {
+	select {
+	case w.events <- event:
+		return true
+	case <-w.quit:
+		return false
+	}
+}
+
+/*
+	Sets the watches which are not set, watching the children which
+	appeared and forgetting the ones which are gone. Returns false when a
+	watch could not be set, to try again later.
+*/
+func (w *Watcher) setWatches() bool {
+	ok := true
+	if !w.nodeWatched {
+		if _, _, ch, err := w.conn.GetW(w.path); err != nil {
+			logger.Printf("Unable to watch %s: %s\n", w.path, err)
+			ok = false
+		} else {
+			w.nodeWatched = true
+			go w.forward(watchEvent{}, ch)
+		}
+	}
+
+	if !w.childrenWatched {
+		children, _, ch, err := w.conn.ChildrenW(w.path)
+		if err != nil {
+			logger.Printf("Unable to watch the children of %s: %s\n", w.path, err)
+			ok = false
+		} else {
+			w.childrenWatched = true
+			go w.forward(watchEvent{children: true}, ch)
+			w.updateChildren(children)
+		}
+	}
+
+	for child, watched := range w.children {
+		if watched {
+			continue
+		}
+		_, _, ch, err := w.conn.GetW(w.path + "/" + child)
+		if err == zk.ErrNoNode {
+			delete(w.children, child)
+		} else if err != nil {
+			logger.Printf("Unable to watch %s/%s: %s\n", w.path, child, err)
+			ok = false
+		} else {
+			w.children[child] = true
+			go w.forward(watchEvent{child: child}, ch)
+		}
+	}
+
+	if ok && w.lost {
+		logger.Printf("Watching %s again\n", w.path)
+		w.lost = false
+		w.send(zk.Event{Type: zk.EventSession, State: zk.StateHasSession, Path: w.path})
+	}
+	return ok
+}
+
+func (w *Watcher) updateChildren(children []string) {
+	current := map[string]bool{}
+	for _, child := range children {
+		current[child] = true
+		if _, known := w.children[child]; !known {
+			w.children[child] = false
+		}
+	}
+	for child := range w.children {
+		if !current[child] {
+			delete(w.children, child)
+		}
+	}
+}
+
+// Waits for a watch to fire
+func (w *Watcher) forward(fired watchEvent, ch <-chan zk.Event) {
+	var ok bool
+	select {
+	case fired.event, ok = <-ch:
+		if !ok {
+			return
+		}
+	case <-w.quit:
+		return
+	}
+
+	select {
+	case w.fired <- fired:
+	case <-w.quit:
+	}
+}
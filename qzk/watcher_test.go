@@ -0,0 +1,209 @@
+package qzk
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// An in-process ZooKeeper holding nodes and firing their watches
+type fakeZK struct {
+	lock         sync.Mutex
+	nodes        map[string][]byte
+	dataWatches  map[string][]chan zk.Event
+	childWatches map[string][]chan zk.Event
+	disconnected bool
+}
+
+func newFakeZK(paths ...string) *fakeZK {
+	f := &fakeZK{nodes: map[string][]byte{}, dataWatches: map[string][]chan zk.Event{},
+		childWatches: map[string][]chan zk.Event{}}
+	for _, p := range paths {
+		f.nodes[p] = []byte{}
+	}
+	return f
+}
+
+func (f *fakeZK) GetW(p string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.disconnected {
+		return nil, nil, nil, zk.ErrNoServer
+	}
+	data, ok := f.nodes[p]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	ch := make(chan zk.Event, 1)
+	f.dataWatches[p] = append(f.dataWatches[p], ch)
+	return data, &zk.Stat{}, ch, nil
+}
+
+func (f *fakeZK) ChildrenW(p string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.disconnected {
+		return nil, nil, nil, zk.ErrNoServer
+	}
+	if _, ok := f.nodes[p]; !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	children := []string{}
+	for node := range f.nodes {
+		if path.Dir(node) == p && node != p {
+			children = append(children, strings.TrimPrefix(node, p+"/"))
+		}
+	}
+	ch := make(chan zk.Event, 1)
+	f.childWatches[p] = append(f.childWatches[p], ch)
+	return children, &zk.Stat{}, ch, nil
+}
+
+// Must hold the lock
+func (f *fakeZK) fire(watches map[string][]chan zk.Event, p string, event zk.Event) {
+	for _, ch := range watches[p] {
+		ch <- event
+		close(ch)
+	}
+	delete(watches, p)
+}
+
+func (f *fakeZK) create(p string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.nodes[p] = []byte{}
+	f.fire(f.childWatches, path.Dir(p), zk.Event{Type: zk.EventNodeChildrenChanged, Path: path.Dir(p)})
+}
+
+func (f *fakeZK) set(p string, data string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.nodes[p] = []byte(data)
+	f.fire(f.dataWatches, p, zk.Event{Type: zk.EventNodeDataChanged, Path: p})
+}
+
+func (f *fakeZK) delete(p string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.nodes, p)
+	f.fire(f.dataWatches, p, zk.Event{Type: zk.EventNodeDeleted, Path: p})
+	f.fire(f.childWatches, path.Dir(p), zk.Event{Type: zk.EventNodeChildrenChanged, Path: path.Dir(p)})
+}
+
+// Expires the session, losing every watch, and stays disconnected
+func (f *fakeZK) expire() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.disconnected = true
+	lost := zk.Event{Type: zk.EventNotWatching, State: zk.StateDisconnected, Err: zk.ErrSessionExpired}
+	for p := range f.dataWatches {
+		f.fire(f.dataWatches, p, lost)
+	}
+	for p := range f.childWatches {
+		f.fire(f.childWatches, p, lost)
+	}
+}
+
+func (f *fakeZK) reconnect() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.disconnected = false
+}
+
+func (f *fakeZK) watchCount(p string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.dataWatches[p])
+}
+
+// Waits until the data of a node is watched, as watches are set again
+// after their events are sent
+func (f *fakeZK) waitForWatch(p string) bool {
+	for i := 0; i < 200; i++ {
+		if f.watchCount(p) > 0 {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func nextEvent(events chan zk.Event) zk.Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		return zk.Event{Type: zk.EventType(-100)}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	Convey("#Watcher", t, func() {
+		fake := newFakeZK("/services", "/services/a")
+		events := make(chan zk.Event)
+		quit := make(chan bool)
+		watcher := newWatcher(fake, "/services", events, quit)
+		watcher.retryDelay = 10 * time.Millisecond
+		go watcher.run()
+		defer close(quit)
+		So(fake.waitForWatch("/services/a"), ShouldBeTrue)
+
+		Convey("should report changes of existing children", func() {
+			fake.set("/services/a", "acl")
+			So(nextEvent(events), ShouldResemble, zk.Event{Type: zk.EventNodeDataChanged, Path: "/services/a"})
+		})
+
+		Convey("should watch children created later", func() {
+			fake.create("/services/b")
+			So(nextEvent(events).Type, ShouldEqual, zk.EventNodeChildrenChanged)
+			So(fake.waitForWatch("/services/b"), ShouldBeTrue)
+
+			fake.set("/services/b", "acl")
+			So(nextEvent(events), ShouldResemble, zk.Event{Type: zk.EventNodeDataChanged, Path: "/services/b"})
+		})
+
+		Convey("should forget deleted children", func() {
+			fake.delete("/services/a")
+			deleted := []zk.EventType{nextEvent(events).Type, nextEvent(events).Type}
+			So(deleted, ShouldContain, zk.EventNodeDeleted)
+			So(deleted, ShouldContain, zk.EventNodeChildrenChanged)
+			So(fake.waitForWatch("/services"), ShouldBeTrue)
+			So(fake.watchCount("/services/a"), ShouldEqual, 0)
+		})
+
+		Convey("should watch everything again after a session expiry", func() {
+			fake.expire()
+			time.Sleep(30 * time.Millisecond)
+			fake.reconnect()
+			So(nextEvent(events).Type, ShouldEqual, zk.EventSession)
+
+			So(fake.waitForWatch("/services/a"), ShouldBeTrue)
+			fake.set("/services/a", "acl")
+			So(nextEvent(events).Path, ShouldEqual, "/services/a")
+
+			fake.create("/services/c")
+			So(nextEvent(events).Type, ShouldEqual, zk.EventNodeChildrenChanged)
+			So(fake.waitForWatch("/services/c"), ShouldBeTrue)
+		})
+	})
+
+	Convey("#Watcher after quitting", t, func() {
+		fake := newFakeZK("/services", "/services/a")
+		events := make(chan zk.Event)
+		quit := make(chan bool)
+		Watch(fake, "/services", events, quit)
+		So(fake.waitForWatch("/services/a"), ShouldBeTrue)
+
+		Convey("should stop watching", func() {
+			close(quit)
+			fake.set("/services/a", "acl")
+			time.Sleep(20 * time.Millisecond)
+			So(fake.watchCount("/services/a"), ShouldEqual, 0)
+		})
+	})
+}
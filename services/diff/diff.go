@@ -0,0 +1,249 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Number of unchanged lines shown around each change
+const DefaultContext = 3
+
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+// An edit turning the old lines into the new ones
+type op struct {
+	Kind opKind
+	// 0-based position of the line in the old and new text
+	OldLine int
+	NewLine int
+	Text    string
+}
+
+/*
+	Unified returns the differences between two texts in the unified diff
+	format, with context unchanged lines around each change. It returns an
+	empty string when the texts are identical.
+
+	Parameters:
+		oldName, newName: names shown in the --- and +++ header lines
+*/
+func Unified(oldText string, newText string, oldName string, newName string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range hunks(ops, context) {
+		writeHunk(&buf, ops[hunk[0]:hunk[1]])
+	}
+	return buf.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+/*
+	Computes the shortest edit script between a and b with the linear space
+	variant of the Myers algorithm, see "An O(ND) Difference Algorithm and
+	Its Variations": the middle snake of the edit path splits the texts in
+	two, which are compared in turn. Memory use is O(N+M) whatever the
+	number of differences.
+*/
+func diffLines(a []string, b []string) []op {
+	ops := []op{}
+	compare(a, b, 0, len(a), 0, len(b), &ops)
+	return ops
+}
+
+// Appends the edits turning a[aLo:aHi] into b[bLo:bHi]
+func compare(a []string, b []string, aLo int, aHi int, bLo int, bHi int, ops *[]op) {
+	for aLo < aHi && bLo < bHi && a[aLo] == b[bLo] {
+		*ops = append(*ops, op{opEqual, aLo, bLo, a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && a[aHi-suffix-1] == b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi, bHi = aHi-suffix, bHi-suffix
+
+	// Without a split, the ranges have nothing in common left
+	x, y, split := 0, 0, false
+	if aLo < aHi && bLo < bHi {
+		x, y, split = middleSnake(a[aLo:aHi], b[bLo:bHi])
+	}
+	if split {
+		compare(a, b, aLo, aLo+x, bLo, bLo+y, ops)
+		compare(a, b, aLo+x, aHi, bLo+y, bHi, ops)
+	} else {
+		for x := aLo; x < aHi; x++ {
+			*ops = append(*ops, op{opDelete, x, bLo, a[x]})
+		}
+		for y := bLo; y < bHi; y++ {
+			*ops = append(*ops, op{opInsert, aHi, y, b[y]})
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		*ops = append(*ops, op{opEqual, aHi + i, bHi + i, a[aHi+i]})
+	}
+}
+
+/*
+	Returns where the shortest edit path between a and b, which differ at
+	their first and last lines, crosses from the forward search to the
+	backward one. Both searches keep the furthest x reached on every
+	diagonal k = x - y, the backward one counting from the ends, and stop
+	following diagonals which left the edit graph.
+*/
+func middleSnake(a []string, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	size := 2*maxD + 2
+	forward := make([]int, size)
+	backward := make([]int, size)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// The paths meet during the forward search when delta is odd
+	odd := delta%2 != 0
+	// Diagonals trimmed from each end of the searched range
+	forwardStart, forwardEnd, backwardStart, backwardEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		for k := -d + forwardStart; k <= d-forwardEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			if x > n {
+				forwardEnd += 2
+			} else if y > m {
+				forwardStart += 2
+			} else if other := offset + delta - k; odd && other >= 0 && other < size && backward[other] != -1 {
+				if x >= n-backward[other] {
+					return x, y, true
+				}
+			}
+		}
+
+		for k := -d + backwardStart; k <= d-backwardEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if x > n {
+				backwardEnd += 2
+			} else if y > m {
+				backwardStart += 2
+			} else if other := offset + delta - k; !odd && other >= 0 && other < size && forward[other] != -1 {
+				forwardX := forward[other]
+				if forwardX >= n-x {
+					return forwardX, forwardX - (other - offset), true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Returns the [start, end) ranges of ops making up each hunk
+func hunks(ops []op, context int) [][2]int {
+	result := [][2]int{}
+	for i := 0; i < len(ops); i++ {
+		if ops[i].Kind == opEqual {
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk while changes are close enough to share context
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].Kind != opEqual {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		i = end - 1
+
+		end += context
+		if end > len(ops) {
+			end = len(ops)
+		}
+		if len(result) > 0 && start <= result[len(result)-1][1] {
+			result[len(result)-1][1] = end
+		} else {
+			result = append(result, [2]int{start, end})
+		}
+	}
+	return result
+}
+
+func writeHunk(buf *bytes.Buffer, ops []op) {
+	oldCount, newCount := 0, 0
+	for _, o := range ops {
+		if o.Kind != opInsert {
+			oldCount++
+		}
+		if o.Kind != opDelete {
+			newCount++
+		}
+	}
+
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n",
+		hunkRange(ops[0].OldLine, oldCount), hunkRange(ops[0].NewLine, newCount))
+	for _, o := range ops {
+		buf.WriteByte(byte(o.Kind))
+		buf.WriteString(o.Text)
+		buf.WriteByte('\n')
+	}
+}
+
+// Ranges are 1-based, an empty range refers to the line before it
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnified(t *testing.T) {
+	Convey("#Unified", t, func() {
+		Convey("should be empty for identical texts", func() {
+			So(Unified("a\nb\n", "a\nb\n", "old", "new", DefaultContext), ShouldEqual, "")
+		})
+
+		Convey("should show changes with their context", func() {
+			oldText := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+			newText := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n"
+			So(Unified(oldText, newText, "old", "new", 1), ShouldEqual, `--- old
++++ new
+@@ -4,3 +4,3 @@
+ 4
+-5
++five
+ 6
+@@ -9 +9,2 @@
+ 9
++10
+`)
+		})
+
+		Convey("should merge changes sharing their context", func() {
+			So(Unified("a\nb\nc\n", "x\nb\ny\n", "old", "new", 1), ShouldEqual, `--- old
++++ new
+@@ -1,3 +1,3 @@
+-a
++x
+ b
+-c
++y
+`)
+		})
+
+		Convey("should handle empty texts", func() {
+			So(Unified("", "a\n", "old", "new", DefaultContext), ShouldEqual, "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n")
+		})
+	})
+}
+
+func lcsLength(a []string, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] > lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func randomLines(random *rand.Rand) []string {
+	lines := make([]string, random.Intn(12))
+	for i := range lines {
+		lines[i] = string('a' + rune(random.Intn(3)))
+	}
+	return lines
+}
+
+func TestDiffLines(t *testing.T) {
+	Convey("#diffLines", t, func() {
+		Convey("should find a shortest edit script", func() {
+			random := rand.New(rand.NewSource(1))
+			for i := 0; i < 500; i++ {
+				a, b := randomLines(random), randomLines(random)
+				var oldLines, newLines []string
+				edits := 0
+				for _, o := range diffLines(a, b) {
+					if o.Kind != opInsert {
+						oldLines = append(oldLines, o.Text)
+					}
+					if o.Kind != opDelete {
+						newLines = append(newLines, o.Text)
+					}
+					if o.Kind != opEqual {
+						edits++
+					}
+				}
+				So(strings.Join(oldLines, ","), ShouldEqual, strings.Join(a, ","))
+				So(strings.Join(newLines, ","), ShouldEqual, strings.Join(b, ","))
+				So(edits, ShouldEqual, len(a)+len(b)-2*lcsLength(a, b))
+			}
+		})
+
+		Convey("should handle large unrelated texts", func() {
+			a := make([]string, 4000)
+			b := make([]string, 4000)
+			for i := range a {
+				a[i] = "old " + string(rune(i))
+				b[i] = "new " + string(rune(i))
+			}
+			So(len(diffLines(a, b)), ShouldEqual, 8000)
+		})
+	})
+}
@@ -2,19 +2,26 @@ package event_bus
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/samuel/go-zookeeper/zk"
 	"github.com/seomoz/roger-bamboo/configuration"
 	"github.com/seomoz/roger-bamboo/services/haproxy"
+	"github.com/seomoz/roger-bamboo/services/marathon"
+	"github.com/seomoz/roger-bamboo/services/service"
+	"github.com/seomoz/roger-bamboo/services/source"
 	"github.com/seomoz/roger-bamboo/services/template"
 	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os/exec"
 	"reflect"
-	"strings"
+	"regexp"
+	"strconv"
+	"time"
 )
 
 type MarathonEvent struct {
@@ -33,30 +40,55 @@ type ServiceEvent struct {
 	EventType string
 }
 
+// Published when the apps of a source may have changed
+type SourceEvent struct {
+	Source string
+	Reason string
+}
+
 type Handlers struct {
 	Conf      *configuration.Configuration
+	Store     service.Store
 	Zookeeper *zk.Conn
+	Source    source.Source
 }
 
 func (h *Handlers) MarathonEventHandler(event MarathonEvent) {
 	log.Printf("%s => %s\n", event.EventType, event.Timestamp)
-	queueUpdate(h)
+	queueUpdate(h, TriggerMarathon)
 	h.Conf.StatsD.Increment(1.0, "reload.marathon", 1)
 }
 
+func (h *Handlers) SourceEventHandler(event SourceEvent) {
+	log.Printf("%s => %s\n", event.Source, event.Reason)
+	queueUpdate(h, event.Source)
+	h.Conf.StatsD.Increment(1.0, "reload."+statsdName(event.Source), 1)
+}
+
 func (h *Handlers) ServiceEventHandler(event ServiceEvent) {
 	log.Println("Domain mapping: Stated changed")
-	queueUpdate(h)
+	trigger := h.Conf.Bamboo.StoreType()
+	if event.EventType == TriggerPeriodic {
+		trigger = TriggerPeriodic
+	}
+	queueUpdate(h, trigger)
 	h.Conf.StatsD.Increment(1.0, "reload.domain", 1)
 }
 
-var updateChan = make(chan *Handlers, 1)
+// A pending update, remembering what caused it
+type updateRequest struct {
+	handlers *Handlers
+	trigger  string
+}
+
+var updateChan = make(chan updateRequest, 1)
 
 var isConfigStale = true
 var currentConfig = ""
 var currentConfigHash = ""
 var hasher = fnv.New64a()                    // The hash function
 var currentTemplateData haproxy.TemplateData // The current data from Marathon
+var currentAppErrors = []marathon.AppError{}  // Invalid app settings in the latest data
 
 /* Called by the webserver to report the hash of the current config. */
 func GetCurrentConfigHash(w http.ResponseWriter, r *http.Request) {
@@ -67,7 +99,12 @@ func GetCurrentConfigHash(w http.ResponseWriter, r *http.Request) {
 func GetCurrentConfig(w http.ResponseWriter, r *http.Request) {
 	msg := ""
 	if isConfigStale {
-		msg = "## WARNING - Haproxy config may be stale. ## -- This line is not part of the config.\n\n"
+		msg = "## WARNING - Haproxy config may be stale. ## -- This line is not part of the config.\n"
+		if lastFailure != nil {
+			msg += fmt.Sprintf("## Last update failed at %s during %s: %s\n",
+				lastFailure.Time.Format(time.RFC3339), lastFailure.Stage, lastFailure.Error)
+		}
+		msg += "\n"
 	} else {
 		msg = ""
 	}
@@ -85,13 +122,20 @@ func GetUsedPorts(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, buf.String())
 }
 
+/* Called by the webserver to report the invalid settings of the apps. */
+func GetAppErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	payload, _ := json.Marshal(currentAppErrors)
+	w.Write(payload)
+}
+
 func init() {
 	go func() {
 		log.Println("Starting update loop ...")
 		for {
-			h := <-updateChan
+			request := <-updateChan
 			log.Println("Got request for new update")
-			handleHAPUpdate(h.Conf, h.Zookeeper)
+			handleHAPUpdate(request.handlers, request.trigger)
 			log.Println("Finished processing new update")
 		}
 	}()
@@ -99,7 +143,7 @@ func init() {
 
 var queueUpdateSem = make(chan int, 1)
 
-func queueUpdate(h *Handlers) {
+func queueUpdate(h *Handlers, trigger string) {
 	queueUpdateSem <- 1
 	select {
 	case _ = <-updateChan:
@@ -107,11 +151,12 @@ func queueUpdate(h *Handlers) {
 	default:
 		log.Println("Queuing an haproxy update.")
 	}
-	updateChan <- h
+	updateChan <- updateRequest{h, trigger}
 	<-queueUpdateSem
 }
 
-func handleHAPUpdate(conf *configuration.Configuration, conn *zk.Conn) bool {
+func handleHAPUpdate(handlers *Handlers, trigger string) bool {
+	conf := handlers.Conf
 	templateContent, err := ioutil.ReadFile(conf.HAProxy.TemplatePath)
 	if err != nil {
 		log.Panicf("Cannot read template file: %s", err)
@@ -129,9 +174,16 @@ func handleHAPUpdate(conf *configuration.Configuration, conn *zk.Conn) bool {
 	// create a second template which omits the first line (and
 	// hence the part which can differ across machines). The
 	// second template is used to compute the hash.
-	idempotentTemplate := strings.Replace(string(templateContent), "# Template rendered at {{ getTime }}", "", 1)
+	idempotentTemplate := template.IdempotentTemplate(string(templateContent))
 
-	templateData := haproxy.GetTemplateData(conf, conn)
+	templateData, err := haproxy.GetTemplateData(conf, handlers.Store, handlers.Zookeeper, handlers.Source)
+	recordEndpointHealth(conf)
+	if err != nil {
+		// HAProxy keeps running with the previous configuration
+		recordFailure(conf, "fetch", err, "")
+		return false
+	}
+	recordAppErrors(conf, templateData.Apps)
 
         // Any empty updates from Marathon will not result in any Haproxy updates.
 	// Haproxy will continue to use previous state.
@@ -145,38 +197,54 @@ func handleHAPUpdate(conf *configuration.Configuration, conn *zk.Conn) bool {
 
 	newContent, err := template.RenderTemplate(conf.HAProxy.TemplatePath, string(templateContent), templateData)
 	if err != nil {
-		log.Fatalf("Template syntax error: \n %s", err)
+		recordFailure(conf, "render", err, "")
+		return false
 	}
 
 	newIdempotentContent, err := template.RenderTemplate("IdempotentTemplate",
 		string(idempotentTemplate), templateData)
 	if err != nil {
-		log.Fatalf("Idempotent Template syntax error: \n %s", err)
+		recordFailure(conf, "render", err, "")
+		return false
 	}
 
 	if !reflect.DeepEqual(currentTemplateData, templateData) {
-		err := ioutil.WriteFile(conf.HAProxy.OutputPath, []byte(newContent), 0666)
-		if err != nil {
-			log.Fatalf("Failed to write template on path: %s", err)
+		// Server moves are applied without a reload when
+		// possible. A config which fails validation or reloading
+		// is rolled back and currentTemplateData is left
+		// untouched, so that the next update tries again.
+		started := time.Now()
+		reload := Reload{Time: started, Trigger: trigger, Method: MethodRuntime,
+			ConfigHash: configHash(newIdempotentContent), Config: newIdempotentContent, Success: true}
+		if applyRuntimeChanges(conf, currentConfig, newIdempotentContent, newContent) {
+			log.Println("HAProxy: Servers updated through the runtime API")
+		} else {
+			reload.Method = MethodReload
+			reload.Success = applyConfig(conf, newContent)
+		}
+		reload.Duration = time.Since(started)
+		if !reload.Success && lastFailure != nil {
+			reload.Stage = lastFailure.Stage
+			reload.Error = lastFailure.Error
+			reload.Output = lastFailure.Output
+		}
+		history.add(reload)
+		conf.StatsD.Timing(1.0, "update."+reload.Method, reload.Duration)
+
+		if !reload.Success {
+			log.Println("HAProxy: update failed, keeping previous configuration")
 			return false
 		}
-		// Now that the config file corresponding to the new
-		// template data has been written, update the
-		// currentTemplateData variable with the new data.
+		// Now that HAProxy runs the config corresponding to the
+		// new template data, update the currentTemplateData
+		// variable with the new data.
 		currentTemplateData = templateData
-		err = execCommand(conf.HAProxy.ReloadCommand)
-		if err != nil {
-			isConfigStale = true
-			log.Fatalf("HAProxy: update failed\n")
-		} else {
-			log.Println("HAProxy: Configuration updated")
-			// Now that the HAproxy config has been
-			// updated, start exporting the new values.
-			currentConfig = newIdempotentContent
-			hasher.Write([]byte(currentConfig))
-			currentConfigHash = fmt.Sprintf("%X", hasher.Sum64())
-			hasher.Reset()
-		}
+		haproxy.RecordApplied(templateData)
+		log.Println("HAProxy: Configuration updated")
+		// Now that the HAproxy config has been
+		// updated, start exporting the new values.
+		currentConfig = newIdempotentContent
+		currentConfigHash = reload.ConfigHash
 		return true
 	} else {
 		log.Println("HAProxy: Same content, no need to reload")
@@ -184,7 +252,60 @@ func handleHAPUpdate(conf *configuration.Configuration, conn *zk.Conn) bool {
 	}
 }
 
-func execCommand(cmd string) error {
+func configHash(content string) string {
+	hasher.Write([]byte(content))
+	hash := fmt.Sprintf("%X", hasher.Sum64())
+	hasher.Reset()
+	return hash
+}
+
+func recordAppErrors(conf *configuration.Configuration, apps marathon.AppList) {
+	// Empty updates are skipped, keep reporting the previous errors
+	if len(apps) == 0 {
+		return
+	}
+	currentAppErrors = apps.Errors()
+	for _, appError := range currentAppErrors {
+		log.Printf("Invalid %s in app %s: %s\n", appError.Field, appError.AppId, appError.Message)
+	}
+	conf.StatsD.Gauge(1.0, "apps.errors", strconv.Itoa(len(currentAppErrors)))
+}
+
+/*
+	Reports the health of the endpoints of every Marathon cluster to StatsD,
+	as gauges named after the host and port of each endpoint.
+*/
+func recordEndpointHealth(conf *configuration.Configuration) {
+	available := 0
+	for _, health := range marathon.ClusterEndpointStatus(conf.MarathonClusters()) {
+		bucket := "marathon.endpoint." + statsdName(health.Endpoint)
+		conf.StatsD.Gauge(1.0, bucket+".available", boolGauge(health.Available))
+		conf.StatsD.Gauge(1.0, bucket+".leader", boolGauge(health.Leader))
+		conf.StatsD.Gauge(1.0, bucket+".failures", strconv.Itoa(health.Failures))
+		if health.Available {
+			available++
+		}
+	}
+	conf.StatsD.Gauge(1.0, "marathon.endpoints.available", strconv.Itoa(available))
+}
+
+var statsdUnsafe = regexp.MustCompile("[^A-Za-z0-9_-]+")
+
+func statsdName(endpoint string) string {
+	if parsed, err := url.Parse(endpoint); err == nil && parsed.Host != "" {
+		endpoint = parsed.Host
+	}
+	return statsdUnsafe.ReplaceAllString(endpoint, "_")
+}
+
+func boolGauge(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func execCommand(cmd string) (string, error) {
 	log.Printf("Exec cmd: %s \n", cmd)
 	output, err := exec.Command("sh", "-c", cmd).CombinedOutput()
 	if err != nil {
@@ -192,5 +313,5 @@ func execCommand(cmd string) error {
 		log.Println("Problem executing command Output:\n" + string(output[:]))
 	}
 	log.Println("Finished running command")
-	return err
+	return string(output), err
 }
@@ -0,0 +1,262 @@
+package event_bus
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/services/haproxy"
+	"github.com/seomoz/roger-bamboo/services/haproxy/stats_socket"
+)
+
+// Describes the last failed attempt to update the HAProxy configuration
+type UpdateFailure struct {
+	Time time.Time
+	// Step which failed: render, write, validate or reload
+	Stage  string
+	Error  string
+	Output string
+}
+
+var lastFailure *UpdateFailure
+
+func recordFailure(conf *configuration.Configuration, stage string, err error, output string) {
+	log.Printf("HAProxy: %s failed: %s\n", stage, err)
+	lastFailure = &UpdateFailure{
+		Time:   time.Now(),
+		Stage:  stage,
+		Error:  err.Error(),
+		Output: output,
+	}
+	isConfigStale = true
+	conf.StatsD.Increment(1.0, "update.failed."+stage, 1)
+}
+
+/*
+	Validates content, atomically moves it into HAProxy.OutputPath and
+	reloads HAProxy. The previous configuration is kept at
+	HAProxy.LastGoodPath() and restored when the reload fails. Returns
+	whether HAProxy now runs with content.
+*/
+func applyConfig(conf *configuration.Configuration, content string) bool {
+	hap := conf.HAProxy
+
+	tmpPath, err := writeTempFile(hap.OutputPath, []byte(content))
+	if err != nil {
+		recordFailure(conf, "write", err, "")
+		return false
+	}
+	// A no-op once the file has been renamed into place
+	defer os.Remove(tmpPath)
+
+	if hap.ValidateCommand != "" {
+		output, err := execCommand(hap.ValidateCommandFor(tmpPath))
+		if err != nil {
+			recordFailure(conf, "validate", err, output)
+			return false
+		}
+	}
+
+	if err := saveLastGood(hap); err != nil {
+		recordFailure(conf, "write", err, "")
+		return false
+	}
+
+	if err := os.Rename(tmpPath, hap.OutputPath); err != nil {
+		recordFailure(conf, "write", err, "")
+		return false
+	}
+
+	output, err := execCommand(hap.ReloadCommand)
+	if err != nil {
+		recordFailure(conf, "reload", err, output)
+		rollback(hap)
+		return false
+	}
+
+	lastFailure = nil
+	return true
+}
+
+/*
+	Applies content through the HAProxy runtime API when its idempotent
+	version only differs from the running one by server addresses and
+	states. content is validated first, then written to OutputPath without
+	a reload, so that the next reload starts from it. Returns false when
+	HAProxy has to be reloaded instead, which reports validation failures.
+*/
+func applyRuntimeChanges(conf *configuration.Configuration, runningConfig string, newIdempotentContent string, content string) bool {
+	if conf.HAProxy.StatsSocket == "" || runningConfig == "" {
+		return false
+	}
+
+	servers, ok := haproxy.RuntimeChanges(runningConfig, newIdempotentContent)
+	if !ok {
+		log.Println("HAProxy: topology changed, a reload is required")
+		return false
+	}
+
+	// Nothing is sent to HAProxy for a config it would refuse to reload
+	if conf.HAProxy.ValidateCommand != "" {
+		if _, err := ValidateConfig(conf.HAProxy, content); err != nil {
+			log.Printf("HAProxy: new configuration is invalid, falling back to a reload: %s\n", err)
+			return false
+		}
+	}
+
+	client := stats_socket.New(conf.HAProxy.StatsSocket)
+	for _, server := range servers {
+		if err := setServer(client, server); err != nil {
+			// The reload puts every server in its new state
+			log.Printf("HAProxy: runtime update failed, falling back to a reload: %s\n", err)
+			conf.StatsD.Increment(1.0, "update.runtime.failed", 1)
+			return false
+		}
+	}
+
+	if err := saveLastGood(conf.HAProxy); err != nil {
+		log.Printf("HAProxy: failed to keep last known good configuration: %s\n", err)
+	}
+	if err := replaceFile(conf.HAProxy.OutputPath, []byte(content)); err != nil {
+		recordFailure(conf, "write", err, "")
+		return false
+	}
+
+	lastFailure = nil
+	conf.StatsD.Increment(1.0, "update.runtime", 1)
+	return true
+}
+
+func setServer(client *stats_socket.Client, server haproxy.Server) error {
+	if server.Disabled {
+		return client.SetServerState(server.Backend, server.Name, stats_socket.StateMaint)
+	}
+
+	// The runtime API only accepts IP addresses
+	ip, err := resolveHost(server.Host)
+	if err != nil {
+		return err
+	}
+	if err := client.SetServerAddr(server.Backend, server.Name, ip, server.Port); err != nil {
+		return err
+	}
+	// The check port of the task the server now points to, which is the
+	// server port unless set otherwise
+	if server.Check {
+		checkPort := server.CheckPort
+		if checkPort == 0 {
+			checkPort = server.Port
+		}
+		if err := client.SetServerCheckPort(server.Backend, server.Name, checkPort); err != nil {
+			return err
+		}
+	}
+	return client.SetServerState(server.Backend, server.Name, stats_socket.StateReady)
+}
+
+// Returns an IP address of host, preferring IPv4
+func resolveHost(host string) (string, error) {
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			return addr, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no address found for %s", host)
+	}
+	return addrs[0], nil
+}
+
+/*
+	Runs HAProxy.ValidateCommand against content, written to a temporary
+	file which is removed afterwards. Returns the output of the command.
+*/
+func ValidateConfig(hap configuration.HAProxy, content string) (string, error) {
+	if hap.ValidateCommand == "" {
+		return "", errors.New("no validation command configured")
+	}
+	tmpPath, err := writeTempFile(filepath.Join(os.TempDir(), "haproxy.cfg"), []byte(content))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath)
+	return execCommand(hap.ValidateCommandFor(tmpPath))
+}
+
+// Keeps a copy of the configuration currently in place, if any
+func saveLastGood(hap configuration.HAProxy) error {
+	content, err := ioutil.ReadFile(hap.OutputPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(hap.LastGoodPath(), content)
+}
+
+// Puts the last known good configuration back into place
+func rollback(hap configuration.HAProxy) {
+	content, err := ioutil.ReadFile(hap.LastGoodPath())
+	if err != nil {
+		log.Printf("HAProxy: no last known good configuration to restore: %s\n", err)
+		return
+	}
+	if err := replaceFile(hap.OutputPath, content); err != nil {
+		log.Printf("HAProxy: failed to restore last known good configuration: %s\n", err)
+		return
+	}
+	log.Println("HAProxy: restored last known good configuration")
+}
+
+// Atomically replaces the file at path with content
+func replaceFile(path string, content []byte) error {
+	tmpPath, err := writeTempFile(path, content)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// Writes content to a new temporary file next to path, so that it can be
+// renamed over path without crossing file systems
+func writeTempFile(path string, content []byte) (string, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := ioutil.TempFile(dir, "."+base+".")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write %s: %s", file.Name(), err)
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,118 @@
+package event_bus
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+)
+
+func TestApplyConfig(t *testing.T) {
+	Convey("#applyConfig", t, func() {
+		dir, _ := ioutil.TempDir("", "bamboo")
+		defer os.RemoveAll(dir)
+
+		conf := &configuration.Configuration{}
+		conf.HAProxy.OutputPath = filepath.Join(dir, "haproxy.cfg")
+		conf.HAProxy.ReloadCommand = "true"
+		ioutil.WriteFile(conf.HAProxy.OutputPath, []byte("old"), 0644)
+
+		read := func(path string) string {
+			content, _ := ioutil.ReadFile(path)
+			return string(content)
+		}
+
+		Convey("should put a valid config in place and keep the previous one", func() {
+			conf.HAProxy.ValidateCommand = "grep -q new {{.}}"
+			So(applyConfig(conf, "new"), ShouldBeTrue)
+			So(read(conf.HAProxy.OutputPath), ShouldEqual, "new")
+			So(read(conf.HAProxy.LastGoodPath()), ShouldEqual, "old")
+			So(lastFailure, ShouldBeNil)
+		})
+
+		Convey("should not touch the output when validation fails", func() {
+			conf.HAProxy.ValidateCommand = "false"
+			So(applyConfig(conf, "new"), ShouldBeFalse)
+			So(read(conf.HAProxy.OutputPath), ShouldEqual, "old")
+			So(lastFailure.Stage, ShouldEqual, "validate")
+		})
+
+		Convey("should restore the previous config when the reload fails", func() {
+			conf.HAProxy.ReloadCommand = "false"
+			So(applyConfig(conf, "new"), ShouldBeFalse)
+			So(read(conf.HAProxy.OutputPath), ShouldEqual, "old")
+			So(lastFailure.Stage, ShouldEqual, "reload")
+		})
+
+		Convey("should leave no temporary files behind", func() {
+			conf.HAProxy.ValidateCommand = "false"
+			applyConfig(conf, "new")
+			files, _ := ioutil.ReadDir(dir)
+			So(len(files), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestApplyRuntimeChanges(t *testing.T) {
+	Convey("#applyRuntimeChanges", t, func() {
+		dir, _ := ioutil.TempDir("", "bamboo")
+		defer os.RemoveAll(dir)
+
+		conf := &configuration.Configuration{}
+		conf.HAProxy.OutputPath = filepath.Join(dir, "haproxy.cfg")
+		conf.HAProxy.StatsSocket = filepath.Join(dir, "admin.sock")
+		runningConfig := "backend app\n  server app-0 10.0.0.1:31000 check\n"
+		newConfig := "backend app\n  server app-0 10.0.0.2:31000 check\n"
+		ioutil.WriteFile(conf.HAProxy.OutputPath, []byte(runningConfig), 0644)
+
+		// Fake stats socket recording the commands it receives
+		listener, err := net.Listen("unix", conf.HAProxy.StatsSocket)
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		commands := make(chan string, 10)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				command, _ := bufio.NewReader(conn).ReadString('\n')
+				commands <- strings.TrimSpace(command)
+				if strings.Contains(command, " addr ") {
+					conn.Write([]byte("IP changed from '10.0.0.1' to '10.0.0.2'\n"))
+				} else if strings.Contains(command, " check-port ") {
+					conn.Write([]byte("health check port updated.\n"))
+				}
+				conn.Close()
+			}
+		}()
+
+		read := func() string {
+			content, _ := ioutil.ReadFile(conf.HAProxy.OutputPath)
+			return string(content)
+		}
+
+		Convey("should move servers without a reload", func() {
+			conf.HAProxy.ValidateCommand = "grep -q 10.0.0.2 {{.}}"
+			So(applyRuntimeChanges(conf, runningConfig, newConfig, newConfig), ShouldBeTrue)
+			So(read(), ShouldEqual, newConfig)
+			So(<-commands, ShouldEqual, "set server app/app-0 addr 10.0.0.2 port 31000")
+			So(<-commands, ShouldEqual, "set server app/app-0 check-port 31000")
+			So(<-commands, ShouldEqual, "set server app/app-0 state ready")
+		})
+
+		Convey("should neither update HAProxy nor the output when validation fails", func() {
+			conf.HAProxy.ValidateCommand = "false"
+			So(applyRuntimeChanges(conf, runningConfig, newConfig, newConfig), ShouldBeFalse)
+			So(read(), ShouldEqual, runningConfig)
+			So(len(commands), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,244 @@
+package event_bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenazn/goji/web"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/services/diff"
+)
+
+/*
+	What caused an update of the HAProxy configuration. Changes notified by
+	a source are triggered by the name of the source, e.g. "marathon", and
+	changes of the routing rules by the type of the store, e.g. "zookeeper".
+*/
+const (
+	TriggerMarathon = "marathon"
+	TriggerPeriodic = "periodic"
+)
+
+// How a new configuration was applied
+const (
+	MethodReload  = "reload"
+	MethodRuntime = "runtime"
+)
+
+const defaultHistorySize = 50
+
+// An attempt to apply a new HAProxy configuration
+type Reload struct {
+	Id         int
+	Time       time.Time
+	Trigger    string
+	Method     string
+	ConfigHash string
+	Success    bool
+	// Failed step and its error, see UpdateFailure
+	Stage    string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+	Output   string `json:",omitempty"`
+	Duration time.Duration
+	// Rendered configuration, without the line holding the render time
+	Config string `json:",omitempty"`
+}
+
+type reloadHistory struct {
+	lock    sync.RWMutex
+	entries []Reload
+	nextId  int
+	size    int
+	// Directory the entries are persisted to, if any
+	path string
+}
+
+var history = &reloadHistory{nextId: 1, size: defaultHistorySize}
+
+/*
+	Configures the size and persistence of the reload history, and loads
+	the entries persisted by a previous run.
+*/
+func LoadReloadHistory(conf configuration.HAProxy) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	if conf.ReloadHistorySize > 0 {
+		history.size = conf.ReloadHistorySize
+	}
+	history.path = conf.ReloadHistoryPath
+	if history.path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(history.path, 0755); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(history.path, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var entry Reload
+		if err := json.Unmarshal(content, &entry); err != nil {
+			log.Printf("Skipping unreadable reload history entry %s: %s\n", file, err)
+			continue
+		}
+		history.entries = append(history.entries, entry)
+	}
+
+	sort.Sort(byId(history.entries))
+	if len(history.entries) > 0 {
+		history.nextId = history.entries[len(history.entries)-1].Id + 1
+	}
+	history.prune()
+	return nil
+}
+
+// Appends an entry to the history, assigning its id
+func (h *reloadHistory) add(entry Reload) Reload {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	entry.Id = h.nextId
+	h.nextId++
+	h.entries = append(h.entries, entry)
+
+	if h.path != "" {
+		content, _ := json.Marshal(entry)
+		if err := replaceFile(h.entryPath(entry.Id), content); err != nil {
+			log.Printf("Failed to persist reload history entry: %s\n", err)
+		}
+	}
+	h.prune()
+	return entry
+}
+
+// Drops the oldest entries beyond the history size. Must hold the lock.
+func (h *reloadHistory) prune() {
+	for len(h.entries) > h.size {
+		if h.path != "" {
+			os.Remove(h.entryPath(h.entries[0].Id))
+		}
+		h.entries = h.entries[1:]
+	}
+}
+
+func (h *reloadHistory) entryPath(id int) string {
+	return filepath.Join(h.path, fmt.Sprintf("%d.json", id))
+}
+
+func (h *reloadHistory) get(id int) (Reload, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for _, entry := range h.entries {
+		if entry.Id == id {
+			return entry, true
+		}
+	}
+	return Reload{}, false
+}
+
+// Returns the entries, newest first, without their configuration
+func (h *reloadHistory) list() []Reload {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	result := make([]Reload, 0, len(h.entries))
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		entry := h.entries[i]
+		entry.Config = ""
+		result = append(result, entry)
+	}
+	return result
+}
+
+// Returns the entry preceding id, if it is still in the history
+func (h *reloadHistory) previous(id int) (Reload, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for i, entry := range h.entries {
+		if entry.Id == id && i > 0 {
+			return h.entries[i-1], true
+		}
+	}
+	return Reload{}, false
+}
+
+type byId []Reload
+
+func (slice byId) Len() int           { return len(slice) }
+func (slice byId) Less(i, j int) bool { return slice[i].Id < slice[j].Id }
+func (slice byId) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+
+/* Called by the webserver to list the recent reloads, newest first. */
+func GetReloads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	payload, _ := json.Marshal(history.list())
+	w.Write(payload)
+}
+
+/* Called by the webserver to report the configuration of a reload. */
+func GetReloadConfig(c web.C, w http.ResponseWriter, r *http.Request) {
+	entry, ok := reloadFromParam(w, c.URLParams["id"])
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, entry.Config)
+}
+
+/*
+	Called by the webserver to report the unified diff of the configuration
+	of a reload against the one of the reload given by the "against" query
+	parameter, which defaults to the previous reload.
+*/
+func GetReloadDiff(c web.C, w http.ResponseWriter, r *http.Request) {
+	entry, ok := reloadFromParam(w, c.URLParams["id"])
+	if !ok {
+		return
+	}
+
+	var other Reload
+	if against := r.URL.Query().Get("against"); against != "" {
+		if other, ok = reloadFromParam(w, against); !ok {
+			return
+		}
+	} else if other, ok = history.previous(entry.Id); !ok {
+		http.Error(w, "No previous reload in the history", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, diff.Unified(other.Config, entry.Config,
+		"reload "+strconv.Itoa(other.Id), "reload "+strconv.Itoa(entry.Id), diff.DefaultContext))
+}
+
+func reloadFromParam(w http.ResponseWriter, param string) (Reload, bool) {
+	id, err := strconv.Atoi(strings.TrimSpace(param))
+	if err != nil {
+		http.Error(w, "Invalid reload id "+param, http.StatusBadRequest)
+		return Reload{}, false
+	}
+	entry, ok := history.get(id)
+	if !ok {
+		http.Error(w, "Unknown reload "+param, http.StatusNotFound)
+		return Reload{}, false
+	}
+	return entry, true
+}
@@ -0,0 +1,124 @@
+package event_bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zenazn/goji/web"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+)
+
+func addReloads(count int) {
+	for i := 0; i < count; i++ {
+		history.add(Reload{Trigger: TriggerMarathon, Method: MethodReload, Success: true,
+			Config: fmt.Sprintf("global\nbackend app-%d\n", history.nextId)})
+	}
+}
+
+func TestReloadHistory(t *testing.T) {
+	Convey("#reloadHistory", t, func() {
+		history = &reloadHistory{nextId: 1, size: defaultHistorySize}
+
+		Convey("should keep the 50 latest reloads, newest first", func() {
+			addReloads(defaultHistorySize + 5)
+			reloads := history.list()
+			So(len(reloads), ShouldEqual, defaultHistorySize)
+			So(reloads[0].Id, ShouldEqual, defaultHistorySize+5)
+			So(reloads[len(reloads)-1].Id, ShouldEqual, 6)
+			So(reloads[0].Config, ShouldEqual, "")
+
+			_, ok := history.get(5)
+			So(ok, ShouldBeFalse)
+			entry, ok := history.get(6)
+			So(ok, ShouldBeTrue)
+			So(entry.Config, ShouldEqual, "global\nbackend app-6\n")
+		})
+
+		Convey("should persist the reloads and load them again", func() {
+			dir, _ := ioutil.TempDir("", "bamboo")
+			defer os.RemoveAll(dir)
+			conf := configuration.HAProxy{ReloadHistoryPath: dir, ReloadHistorySize: 3}
+
+			So(LoadReloadHistory(conf), ShouldBeNil)
+			addReloads(4)
+			files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+			So(len(files), ShouldEqual, 3)
+			ioutil.WriteFile(filepath.Join(dir, "9.json"), []byte("{"), 0644)
+
+			history = &reloadHistory{nextId: 1, size: defaultHistorySize}
+			So(LoadReloadHistory(conf), ShouldBeNil)
+			reloads := history.list()
+			So(len(reloads), ShouldEqual, 3)
+			So(reloads[0].Id, ShouldEqual, 4)
+			So(reloads[2].Id, ShouldEqual, 2)
+			entry, _ := history.get(4)
+			So(entry.Config, ShouldEqual, "global\nbackend app-4\n")
+
+			So(history.add(Reload{}).Id, ShouldEqual, 5)
+			_, err := os.Stat(filepath.Join(dir, "2.json"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestReloadHandlers(t *testing.T) {
+	Convey("#GetReloads", t, func() {
+		history = &reloadHistory{nextId: 1, size: defaultHistorySize}
+		addReloads(2)
+
+		get := func(handler func(web.C, http.ResponseWriter, *http.Request), id string, query string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/api/reloads/"+id+query, nil)
+			handler(web.C{URLParams: map[string]string{"id": id}}, recorder, request)
+			return recorder
+		}
+
+		Convey("should list the reloads without their configuration", func() {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/api/reloads", nil)
+			GetReloads(recorder, request)
+
+			var reloads []Reload
+			So(json.Unmarshal(recorder.Body.Bytes(), &reloads), ShouldBeNil)
+			So(len(reloads), ShouldEqual, 2)
+			So(reloads[0].Id, ShouldEqual, 2)
+			So(reloads[0].Config, ShouldEqual, "")
+		})
+
+		Convey("should report the configuration of a reload", func() {
+			recorder := get(GetReloadConfig, "1", "")
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(recorder.Body.String(), ShouldEqual, "global\nbackend app-1\n")
+
+			So(get(GetReloadConfig, "3", "").Code, ShouldEqual, http.StatusNotFound)
+			So(get(GetReloadConfig, "last", "").Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should diff a reload against the previous one by default", func() {
+			recorder := get(GetReloadDiff, "2", "")
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(recorder.Body.String(), ShouldStartWith, "--- reload 1\n+++ reload 2\n")
+			So(recorder.Body.String(), ShouldContainSubstring, "-backend app-1\n+backend app-2\n")
+
+			So(get(GetReloadDiff, "1", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should diff a reload against the given one", func() {
+			recorder := get(GetReloadDiff, "1", "?against=2")
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(recorder.Body.String(), ShouldStartWith, "--- reload 2\n+++ reload 1\n")
+
+			So(get(GetReloadDiff, "1", "?against=first").Code, ShouldEqual, http.StatusBadRequest)
+			So(get(GetReloadDiff, "1", "?against=7").Code, ShouldEqual, http.StatusNotFound)
+			So(get(GetReloadDiff, "7", "").Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package golden
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/seomoz/roger-bamboo/services/diff"
+	"github.com/seomoz/roger-bamboo/services/haproxy"
+	"github.com/seomoz/roger-bamboo/services/template"
+)
+
+/*
+	A fixture is a pair of files sharing their name in a fixture directory:
+	the template data recorded with haproxy.WriteSnapshot, and the config
+	the template is expected to render from it.
+*/
+const (
+	DataExtension     = ".json"
+	ExpectedExtension = ".cfg"
+)
+
+// The outcome of rendering a template against a fixture
+type Result struct {
+	Name string
+	// Unified diff of the expected config against the rendered one
+	Diff string
+	// Set when the fixture could not be read or rendered
+	Err error
+}
+
+func (r Result) Passed() bool {
+	return r.Err == nil && r.Diff == ""
+}
+
+/*
+	Renders the template against every fixture of the directory and compares
+	the output with the expected config. The line holding the render time is
+	left out of the template, see template.IdempotentTemplate.
+
+	When update is set, the expected configs are rewritten with the rendered
+	output instead, and only fixtures failing to render are reported.
+*/
+func Run(templatePath string, dir string, update bool) ([]Result, error) {
+	templateContent, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	names, err := fixtureNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no %s fixtures found in %s", DataExtension, dir)
+	}
+
+	idempotentTemplate := template.IdempotentTemplate(string(templateContent))
+	results := make([]Result, 0, len(names))
+	for _, name := range names {
+		results = append(results, runFixture(templatePath, idempotentTemplate, dir, name, update))
+	}
+	return results, nil
+}
+
+func runFixture(templatePath string, templateContent string, dir string, name string, update bool) Result {
+	result := Result{Name: name}
+	base := filepath.Join(dir, name)
+
+	data, err := haproxy.ReadSnapshot(base + DataExtension)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	rendered, err := template.RenderTemplate(templatePath, templateContent, data)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if update {
+		result.Err = ioutil.WriteFile(base+ExpectedExtension, []byte(rendered), 0644)
+		return result
+	}
+
+	expected, err := ioutil.ReadFile(base + ExpectedExtension)
+	if os.IsNotExist(err) {
+		result.Err = fmt.Errorf("missing expected config %s, run with update to create it", base+ExpectedExtension)
+		return result
+	} else if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Diff = diff.Unified(string(expected), rendered,
+		name+ExpectedExtension, name+" (rendered)", diff.DefaultContext)
+	return result
+}
+
+// Returns the names of the fixtures of the directory, in order
+func fixtureNames(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+DataExtension))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), DataExtension))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// The subset of testing.TB used by Check
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+/*
+	Fails the test for every fixture of the directory the template does not
+	render as expected. Meant to be called from the tests of a template:
+
+		func TestTemplate(t *testing.T) {
+			golden.Check(t, "haproxy_template.cfg", "testdata")
+		}
+*/
+func Check(t TestingT, templatePath string, dir string) {
+	results, err := Run(templatePath, dir, false)
+	if err != nil {
+		t.Fatalf("Unable to run template fixtures: %s", err)
+		return
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("Fixture %s: %s", result.Name, result.Err)
+		} else if result.Diff != "" {
+			t.Errorf("Fixture %s rendered differently than expected:\n%s", result.Name, result.Diff)
+		}
+	}
+}
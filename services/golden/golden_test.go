@@ -0,0 +1,56 @@
+package golden
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const defaultTemplate = "../../config/haproxy_template.cfg"
+const defaultFixtures = "../../config/template_fixtures"
+
+func TestDefaultTemplate(t *testing.T) {
+	Check(t, defaultTemplate, defaultFixtures)
+}
+
+func TestRun(t *testing.T) {
+	Convey("#Run", t, func() {
+		dir, _ := ioutil.TempDir("", "golden")
+		defer os.RemoveAll(dir)
+
+		templatePath := filepath.Join(dir, "template.cfg")
+		ioutil.WriteFile(templatePath, []byte("# Template rendered at {{ getTime }}\n{{ range .Apps }}{{ .Id }}\n{{ end }}"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "apps.json"), []byte(`{"Apps": [{"Id": "/a"}, {"Id": "/b"}]}`), 0644)
+
+		Convey("should report fixtures without an expected config", func() {
+			results, err := Run(templatePath, dir, false)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].Err, ShouldNotBeNil)
+		})
+
+		Convey("should write the expected configs without the render time", func() {
+			Run(templatePath, dir, true)
+			content, _ := ioutil.ReadFile(filepath.Join(dir, "apps.cfg"))
+			So(string(content), ShouldEqual, "\n/a\n/b\n")
+
+			results, _ := Run(templatePath, dir, false)
+			So(results[0].Passed(), ShouldBeTrue)
+		})
+
+		Convey("should report the diff against the expected config", func() {
+			ioutil.WriteFile(filepath.Join(dir, "apps.cfg"), []byte("\n/a\n"), 0644)
+			results, _ := Run(templatePath, dir, false)
+			So(results[0].Passed(), ShouldBeFalse)
+			So(results[0].Diff, ShouldContainSubstring, "+/b\n")
+		})
+
+		Convey("should fail without fixtures", func() {
+			_, err := Run(templatePath, filepath.Join(dir, "missing"), false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
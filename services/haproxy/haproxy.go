@@ -1,11 +1,18 @@
 package haproxy
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
 	"github.com/samuel/go-zookeeper/zk"
 
 	conf "github.com/seomoz/roger-bamboo/configuration"
 	"github.com/seomoz/roger-bamboo/services/marathon"
+	"github.com/seomoz/roger-bamboo/services/ports"
 	"github.com/seomoz/roger-bamboo/services/service"
+	"github.com/seomoz/roger-bamboo/services/source"
 )
 
 type TemplateData struct {
@@ -13,14 +20,113 @@ type TemplateData struct {
 	Services map[string]service.Service
 	Acls map[string]bool
 	BackendRules map[string]string
+	PortConflicts []ports.Conflict
 }
 
-func GetTemplateData(config *conf.Configuration, conn *zk.Conn) TemplateData {
+/*
+	Gathers the apps of the sources and the routing rules of the store.
+	Fails when either cannot be read, as rendering without them would drop
+	apps or their routing from the HAProxy configuration.
+
+	Parameters:
+		conn: Zookeeper connection holding the port reservations, if any
+*/
+func GetTemplateData(config *conf.Configuration, store service.Store, conn *zk.Conn, src source.Source) (TemplateData, error) {
 
-	apps, _ := marathon.FetchApps(config.Marathon)
-	services, _ := service.All(conn, config.Bamboo.Zookeeper)
+	apps, err := src.Apps()
+	if err != nil {
+		return TemplateData{}, fmt.Errorf("unable to fetch apps from %s: %s", src.Name(), err)
+	}
+	services, err := store.All()
+	if err != nil {
+		return TemplateData{}, fmt.Errorf("unable to read the services: %s", err)
+	}
+	// Without the reservations, reserved ports could be given away
+	reservations, err := portReservations(config, conn)
+	if err != nil {
+		return TemplateData{}, fmt.Errorf("unable to read the port reservations: %s", err)
+	}
 	acls := make(map[string]bool)
 	backendrules := make(map[string]string)
 
-	return TemplateData{apps, services, acls, backendrules}
+	apps, conflicts := ports.Resolve(apps, reservations, ownership.current())
+
+	return TemplateData{apps, services, acls, backendrules, conflicts}, nil
+}
+
+func portReservations(config *conf.Configuration, conn *zk.Conn) (map[string]string, error) {
+	if config.Bamboo.PortReservationPath == "" {
+		return nil, nil
+	}
+	return ports.Reservations(conn, config.Bamboo.PortReservationPath)
+}
+
+/*
+	The ports used by the configuration HAProxy runs, which port conflicts
+	are settled with. Lost on restart, until which conflicts go to the app
+	with the oldest definition unless the port is reserved.
+*/
+type portOwnership struct {
+	sync.Mutex
+	owners map[string]string
+}
+
+var ownership = &portOwnership{}
+
+/*
+	Records the template data HAProxy now runs. Its apps keep their ports
+	when other apps later claim them.
+*/
+func RecordApplied(data TemplateData) {
+	ownership.Lock()
+	defer ownership.Unlock()
+	ownership.owners = ports.Used(data.Apps)
+}
+
+// Returns a copy of the app ids by port, only changed by RecordApplied
+func (o *portOwnership) current() map[string]string {
+	o.Lock()
+	defer o.Unlock()
+
+	owners := map[string]string{}
+	for port, appId := range o.owners {
+		owners[port] = appId
+	}
+	return owners
+}
+
+/*
+	Writes the template data to a JSON file, so that templates can later be
+	rendered against it without access to Marathon or Zookeeper.
+*/
+func WriteSnapshot(path string, data TemplateData) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+/* Reads template data written by WriteSnapshot */
+func ReadSnapshot(path string) (TemplateData, error) {
+	var data TemplateData
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return data, err
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return data, fmt.Errorf("invalid snapshot %s: %s", path, err)
+	}
+
+	// Templates record their acls and backend rules in these maps
+	if data.Acls == nil {
+		data.Acls = make(map[string]bool)
+	}
+	if data.BackendRules == nil {
+		data.BackendRules = make(map[string]string)
+	}
+	if data.Services == nil {
+		data.Services = make(map[string]service.Service)
+	}
+	return data, nil
 }
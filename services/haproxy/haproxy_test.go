@@ -0,0 +1,27 @@
+package haproxy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+func TestPortOwnership(t *testing.T) {
+	Convey("#RecordApplied", t, func() {
+		ownership = &portOwnership{}
+
+		Convey("should record the ports of the applied apps", func() {
+			So(ownership.current(), ShouldBeEmpty)
+			RecordApplied(TemplateData{Apps: marathon.AppList{{Id: "/a", TcpPorts: map[string]string{"3300": "PORT0"}}}})
+			So(ownership.current(), ShouldResemble, map[string]string{"3300": "/a"})
+		})
+
+		Convey("should hand out copies", func() {
+			RecordApplied(TemplateData{Apps: marathon.AppList{{Id: "/a", TcpPorts: map[string]string{"3300": "PORT0"}}}})
+			ownership.current()["3300"] = "/b"
+			So(ownership.current()["3300"], ShouldEqual, "/a")
+		})
+	})
+}
@@ -0,0 +1,156 @@
+package haproxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// A server line of a rendered HAProxy configuration
+type Server struct {
+	// Name of the backend or listen section holding the server
+	Backend  string
+	Name     string
+	Host     string
+	Port     int
+	Disabled bool
+	// Whether the server is health checked, and the port checked when it
+	// differs from Port, 0 otherwise
+	Check     bool
+	CheckPort int
+}
+
+type serverKey struct {
+	Backend string
+	Name    string
+}
+
+/*
+	Parses the server lines of every backend and listen section of a
+	rendered configuration. Servers whose address cannot be parsed are
+	skipped.
+*/
+func ParseServers(config string) []Server {
+	servers := []Server{}
+	section := ""
+
+	for _, line := range strings.Split(config, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "backend", "listen", "frontend", "defaults", "global":
+			section = ""
+			if (fields[0] == "backend" || fields[0] == "listen") && len(fields) > 1 {
+				section = fields[1]
+			}
+		case "server":
+			if section == "" || len(fields) < 3 {
+				continue
+			}
+			host, portString, err := net.SplitHostPort(fields[2])
+			if err != nil {
+				continue
+			}
+			port, err := strconv.Atoi(portString)
+			if err != nil {
+				continue
+			}
+			servers = append(servers, Server{
+				Backend:   section,
+				Name:      fields[1],
+				Host:      host,
+				Port:      port,
+				Disabled:  hasField(fields[3:], "disabled"),
+				Check:     hasField(fields[3:], "check"),
+				CheckPort: checkPort(fields[3:]),
+			})
+		}
+	}
+	return servers
+}
+
+/*
+	Returns the configuration without comments, blank lines, server
+	addresses, the disabled keyword and the checked port of servers. Two
+	configurations with the same topology only differ by where their
+	servers point to and whether they are enabled, which the runtime API
+	can change without a reload.
+*/
+func Topology(config string) string {
+	lines := []string{}
+	for _, line := range strings.Split(config, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "server" && len(fields) >= 3 {
+			options := []string{}
+			for i := 3; i < len(fields); i++ {
+				switch fields[i] {
+				case "disabled":
+				case "port":
+					// Skips the port number too
+					i++
+				default:
+					options = append(options, fields[i])
+				}
+			}
+			fields = append([]string{"server", fields[1]}, options...)
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+/*
+	RuntimeChanges returns the servers of newConfig which differ from
+	oldConfig. ok is false when the configurations differ by more than
+	server addresses, checked ports and states, in which case HAProxy must
+	be reloaded.
+*/
+func RuntimeChanges(oldConfig string, newConfig string) (changed []Server, ok bool) {
+	if Topology(oldConfig) != Topology(newConfig) {
+		return nil, false
+	}
+
+	oldServers := map[serverKey]Server{}
+	for _, server := range ParseServers(oldConfig) {
+		oldServers[serverKey{server.Backend, server.Name}] = server
+	}
+
+	changed = []Server{}
+	for _, server := range ParseServers(newConfig) {
+		old, found := oldServers[serverKey{server.Backend, server.Name}]
+		if !found {
+			// Same topology but unparsable address in the old config
+			return nil, false
+		}
+		if old != server {
+			changed = append(changed, server)
+		}
+	}
+	return changed, true
+}
+
+// Returns the port set by the "port" option of a server, 0 without one
+func checkPort(options []string) int {
+	for i := 0; i+1 < len(options); i++ {
+		if options[i] == "port" {
+			port, _ := strconv.Atoi(options[i+1])
+			return port
+		}
+	}
+	return 0
+}
+
+func hasField(fields []string, value string) bool {
+	for _, field := range fields {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
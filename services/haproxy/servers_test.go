@@ -0,0 +1,79 @@
+package haproxy
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const runningConfig = `
+# Template rendered at yesterday
+frontend http-in
+        bind *:80
+
+backend app-cluster
+        timeout server 5000
+        server app-1 10.0.0.1:31000 check
+        server app-2 127.0.0.1:1 disabled check
+        server app-3 10.0.0.3:31000 check port 31005
+
+listen app-tcp :3300
+        server app-tcp-1 10.0.0.1:31001
+`
+
+func TestRuntimeChanges(t *testing.T) {
+	Convey("#ParseServers", t, func() {
+		servers := ParseServers(runningConfig)
+		So(servers, ShouldResemble, []Server{
+			{Backend: "app-cluster", Name: "app-1", Host: "10.0.0.1", Port: 31000, Check: true},
+			{Backend: "app-cluster", Name: "app-2", Host: "127.0.0.1", Port: 1, Disabled: true, Check: true},
+			{Backend: "app-cluster", Name: "app-3", Host: "10.0.0.3", Port: 31000, Check: true, CheckPort: 31005},
+			{Backend: "app-tcp", Name: "app-tcp-1", Host: "10.0.0.1", Port: 31001},
+		})
+	})
+
+	Convey("#RuntimeChanges", t, func() {
+		Convey("should list moved and toggled servers", func() {
+			newConfig := `
+frontend http-in
+        bind *:80
+backend app-cluster
+        timeout server 5000
+        server app-1 127.0.0.1:1 disabled check
+        server app-2 10.0.0.2:31000 check
+        server app-3 10.0.0.4:31002 check port 31003
+listen app-tcp :3300
+        server app-tcp-1 10.0.0.1:31001
+`
+			changed, ok := RuntimeChanges(runningConfig, newConfig)
+			So(ok, ShouldBeTrue)
+			So(changed, ShouldResemble, []Server{
+				{Backend: "app-cluster", Name: "app-1", Host: "127.0.0.1", Port: 1, Disabled: true, Check: true},
+				{Backend: "app-cluster", Name: "app-2", Host: "10.0.0.2", Port: 31000, Check: true},
+				{Backend: "app-cluster", Name: "app-3", Host: "10.0.0.4", Port: 31002, Check: true, CheckPort: 31003},
+			})
+		})
+
+		Convey("should not require a reload when the checked port changes", func() {
+			newConfig := strings.Replace(runningConfig, "10.0.0.3:31000 check port 31005", "10.0.0.3:31000 check", 1)
+			changed, ok := RuntimeChanges(runningConfig, newConfig)
+			So(ok, ShouldBeTrue)
+			So(changed, ShouldResemble, []Server{
+				{Backend: "app-cluster", Name: "app-3", Host: "10.0.0.3", Port: 31000, Check: true},
+			})
+		})
+
+		Convey("should require a reload when servers are added", func() {
+			newConfig := runningConfig + "        server app-tcp-2 10.0.0.2:31001\n"
+			_, ok := RuntimeChanges(runningConfig, newConfig)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("should require a reload when server options change", func() {
+			newConfig := strings.Replace(runningConfig, "10.0.0.1:31000 check", "10.0.0.1:31000", 1)
+			_, ok := RuntimeChanges(runningConfig, newConfig)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
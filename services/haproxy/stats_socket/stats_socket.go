@@ -0,0 +1,92 @@
+package stats_socket
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// Server states accepted by "set server ... state"
+const (
+	StateReady = "ready"
+	StateDrain = "drain"
+	StateMaint = "maint"
+)
+
+/*
+	Client of the HAProxy stats socket, which must be declared with
+	"level admin" for the set commands to be accepted. Every command uses
+	its own connection, HAProxy closes it after replying.
+*/
+type Client struct {
+	// Path of the unix socket, e.g. /run/haproxy/admin.sock
+	Path    string
+	Timeout time.Duration
+}
+
+func New(path string) *Client {
+	return &Client{Path: path, Timeout: 5 * time.Second}
+}
+
+// Sends a single command and returns the reply of HAProxy
+func (c *Client) Execute(command string) (string, error) {
+	conn, err := net.DialTimeout("unix", c.Path, c.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return "", err
+	}
+
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(reply)), nil
+}
+
+// Points a server of a backend to a new IP address and port
+func (c *Client) SetServerAddr(backend string, server string, ip string, port int) error {
+	reply, err := c.Execute(fmt.Sprintf("set server %s/%s addr %s port %d", backend, server, ip, port))
+	if err != nil {
+		return err
+	}
+	// HAProxy describes what changed, or that nothing needed to
+	if reply != "" && !strings.Contains(reply, "changed") && !strings.Contains(reply, "no need to change") {
+		return fmt.Errorf("set addr of %s/%s: %s", backend, server, reply)
+	}
+	return nil
+}
+
+/*
+	Changes the port the health checks of a server connect to. HAProxy
+	replies even when the command succeeded.
+*/
+func (c *Client) SetServerCheckPort(backend string, server string, port int) error {
+	reply, err := c.Execute(fmt.Sprintf("set server %s/%s check-port %d", backend, server, port))
+	if err != nil {
+		return err
+	}
+	if reply != "" && !strings.Contains(reply, "updated") {
+		return fmt.Errorf("set check-port of %s/%s: %s", backend, server, reply)
+	}
+	return nil
+}
+
+// Changes the administrative state of a server of a backend
+func (c *Client) SetServerState(backend string, server string, state string) error {
+	reply, err := c.Execute(fmt.Sprintf("set server %s/%s state %s", backend, server, state))
+	if err != nil {
+		return err
+	}
+	// HAProxy only replies when the command failed
+	if reply != "" {
+		return fmt.Errorf("set state of %s/%s: %s", backend, server, reply)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package marathon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+)
+
+/*
+	Returns a client authenticating its requests to Marathon with the
+	credentials and TLS settings of the configuration.
+
+	Parameters:
+		timeout: timeout of a whole request, 0 for none as needed by the
+			event stream
+*/
+func NewClient(maraconf configuration.Marathon, timeout time.Duration) (*http.Client, error) {
+	transport, err := marathonTransport(maraconf)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &authTransport{maraconf: maraconf, base: transport},
+	}, nil
+}
+
+// Returns the client used for API requests to Marathon
+func httpClient(maraconf configuration.Marathon) (*http.Client, error) {
+	return NewClient(maraconf, maraconf.Timeout())
+}
+
+// Adds the Authorization header to the requests
+type authTransport struct {
+	maraconf configuration.Marathon
+	base     http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authorization, err := authorizationHeader(t.maraconf)
+	if err != nil {
+		return nil, err
+	}
+	// Credentials embedded in the endpoint URL are already set
+	if authorization == "" && t.maraconf.User != "" && req.Header.Get("Authorization") == "" {
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(t.maraconf.User+":"+t.maraconf.Password))
+	}
+	if authorization == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		clone.Header[key] = values
+	}
+	clone.Header.Set("Authorization", authorization)
+	return t.base.RoundTrip(clone)
+}
+
+/*
+	Returns the Authorization header for the token of the configuration,
+	or an empty string without a token. A token takes precedence over the
+	basic authentication credentials.
+*/
+func authorizationHeader(maraconf configuration.Marathon) (string, error) {
+	token := maraconf.Token
+	if maraconf.TokenFile != "" {
+		content, err := ioutil.ReadFile(maraconf.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot read Marathon token file: %s", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	if token == "" {
+		return "", nil
+	}
+	if strings.EqualFold(maraconf.TokenType, "Bearer") {
+		return "Bearer " + token, nil
+	}
+	return "token=" + token, nil
+}
+
+var transports = struct {
+	sync.Mutex
+	byKey map[string]*http.Transport
+}{byKey: map[string]*http.Transport{}}
+
+/*
+	Returns the transport for the TLS settings of the configuration. Transports
+	are shared across clients with the same settings, so that connections are
+	reused and idle ones do not pile up.
+*/
+func marathonTransport(maraconf configuration.Marathon) (*http.Transport, error) {
+	key := strings.Join([]string{maraconf.ClientCert, maraconf.ClientKey, maraconf.CACert,
+		fmt.Sprint(maraconf.InsecureSkipVerify)}, "|")
+
+	transports.Lock()
+	defer transports.Unlock()
+	if transport, ok := transports.byKey[key]; ok {
+		return transport, nil
+	}
+
+	tlsConfig, err := tlsConfig(maraconf)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	transports.byKey[key] = transport
+	return transport, nil
+}
+
+func tlsConfig(maraconf configuration.Marathon) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: maraconf.InsecureSkipVerify}
+
+	if maraconf.ClientCert != "" || maraconf.ClientKey != "" {
+		if maraconf.ClientCert == "" || maraconf.ClientKey == "" {
+			return nil, errors.New("Marathon ClientCert and ClientKey must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(maraconf.ClientCert, maraconf.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load Marathon client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if maraconf.CACert != "" {
+		bundle, err := ioutil.ReadFile(maraconf.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read Marathon CA bundle: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificate found in Marathon CA bundle %s", maraconf.CACert)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// Decodes the JSON document at location into v
+func getJSON(client *http.Client, location string, v interface{}) error {
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", location, response.Status)
+	}
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, v)
+}
@@ -0,0 +1,117 @@
+package marathon
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+)
+
+// Returns the Authorization header Marathon receives from a client
+func receivedAuthorization(maraconf configuration.Marathon) (string, error) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("Authorization")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(maraconf, maraconf.Timeout())
+	if err != nil {
+		return "", err
+	}
+	var v map[string]interface{}
+	if err := getJSON(client, server.URL+"/v2/apps", &v); err != nil {
+		return "", err
+	}
+	return <-received, nil
+}
+
+func TestClient(t *testing.T) {
+	Convey("#NewClient", t, func() {
+		Convey("should not authenticate without credentials", func() {
+			header, err := receivedAuthorization(configuration.Marathon{})
+			So(err, ShouldBeNil)
+			So(header, ShouldEqual, "")
+		})
+
+		Convey("should use basic authentication", func() {
+			header, _ := receivedAuthorization(configuration.Marathon{User: "bamboo", Password: "secret"})
+			So(header, ShouldEqual, "Basic YmFtYm9vOnNlY3JldA==")
+		})
+
+		Convey("should send tokens in the DC/OS format", func() {
+			header, _ := receivedAuthorization(configuration.Marathon{Token: "abc", User: "bamboo"})
+			So(header, ShouldEqual, "token=abc")
+		})
+
+		Convey("should send bearer tokens", func() {
+			header, _ := receivedAuthorization(configuration.Marathon{Token: "abc", TokenType: "Bearer"})
+			So(header, ShouldEqual, "Bearer abc")
+		})
+
+		Convey("should read the token file on every request", func() {
+			file, _ := ioutil.TempFile("", "token")
+			defer os.Remove(file.Name())
+			file.WriteString("first\n")
+			file.Close()
+			maraconf := configuration.Marathon{TokenFile: file.Name()}
+
+			header, _ := receivedAuthorization(maraconf)
+			So(header, ShouldEqual, "token=first")
+
+			ioutil.WriteFile(file.Name(), []byte("second"), 0600)
+			header, _ = receivedAuthorization(maraconf)
+			So(header, ShouldEqual, "token=second")
+		})
+
+		Convey("should fail on a missing token file", func() {
+			_, err := receivedAuthorization(configuration.Marathon{TokenFile: "/nonexistent/token"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("#NewClient with TLS", t, func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("{}"))
+		}))
+		defer server.Close()
+
+		get := func(maraconf configuration.Marathon) error {
+			client, err := NewClient(maraconf, maraconf.Timeout())
+			if err != nil {
+				return err
+			}
+			var v map[string]interface{}
+			return getJSON(client, server.URL, &v)
+		}
+
+		Convey("should reject unknown certificates", func() {
+			So(get(configuration.Marathon{}), ShouldNotBeNil)
+		})
+
+		Convey("should verify certificates against the CA bundle", func() {
+			bundle, _ := ioutil.TempFile("", "ca")
+			defer os.Remove(bundle.Name())
+			pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+			bundle.Close()
+
+			So(get(configuration.Marathon{CACert: bundle.Name()}), ShouldBeNil)
+		})
+
+		Convey("should skip the verification when asked to", func() {
+			So(get(configuration.Marathon{InsecureSkipVerify: true}), ShouldBeNil)
+		})
+
+		Convey("should require both the client certificate and key", func() {
+			_, err := NewClient(configuration.Marathon{ClientCert: "cert.pem"}, 0)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
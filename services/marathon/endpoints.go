@@ -0,0 +1,228 @@
+package marathon
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+)
+
+// How long the discovered leader is trusted before asking again
+const leaderRefreshInterval = 30 * time.Second
+
+// Health of a configured Marathon endpoint
+type EndpointHealth struct {
+	Endpoint string
+	Leader   bool
+	// False while the endpoint is cooling down after a failure
+	Available bool
+	// Failed requests since the last successful one
+	Failures      int
+	LastError     string `json:",omitempty"`
+	LastFailureAt time.Time
+	LastSuccessAt time.Time
+	// Requests skip the endpoint until then, unless no other is available
+	CooldownUntil time.Time
+}
+
+/*
+	Health of the endpoints, shared by the clusters listing the same
+	endpoint, and the leader of every cluster
+*/
+type endpointPool struct {
+	lock   sync.Mutex
+	health map[string]*EndpointHealth
+	// Keyed by the endpoints of the cluster, see Marathon.Endpoint
+	leaders map[string]*clusterLeader
+}
+
+type clusterLeader struct {
+	// Configured endpoint the leader is reachable at, if any
+	endpoint  string
+	checkedAt time.Time
+}
+
+var marathonEndpoints = newEndpointPool()
+
+func newEndpointPool() *endpointPool {
+	return &endpointPool{health: map[string]*EndpointHealth{}, leaders: map[string]*clusterLeader{}}
+}
+
+/*
+	Returns the health of every configured endpoint, in the order of the
+	configuration.
+*/
+func EndpointStatus(maraconf configuration.Marathon) []EndpointHealth {
+	marathonEndpoints.lock.Lock()
+	defer marathonEndpoints.lock.Unlock()
+
+	now := time.Now()
+	status := []EndpointHealth{}
+	leader := marathonEndpoints.leader(maraconf)
+	for _, endpoint := range maraconf.Endpoints() {
+		health := *marathonEndpoints.get(endpoint)
+		health.Leader = endpoint == leader.endpoint
+		health.Available = !now.Before(health.CooldownUntil)
+		status = append(status, health)
+	}
+	return status
+}
+
+/*
+	Returns the health of the endpoints of several clusters, in the order of
+	the clusters. Endpoints shared by clusters are only listed once, as the
+	leader of the first cluster listing them if it is.
+*/
+func ClusterEndpointStatus(clusters []configuration.Marathon) []EndpointHealth {
+	status := []EndpointHealth{}
+	listed := map[string]bool{}
+	for _, maraconf := range clusters {
+		for _, health := range EndpointStatus(maraconf) {
+			if !listed[health.Endpoint] {
+				listed[health.Endpoint] = true
+				status = append(status, health)
+			}
+		}
+	}
+	return status
+}
+
+// Must hold the lock
+func (p *endpointPool) get(endpoint string) *EndpointHealth {
+	health, ok := p.health[endpoint]
+	if !ok {
+		health = &EndpointHealth{Endpoint: endpoint, Available: true}
+		p.health[endpoint] = health
+	}
+	return health
+}
+
+// Must hold the lock
+func (p *endpointPool) leader(maraconf configuration.Marathon) *clusterLeader {
+	leader, ok := p.leaders[maraconf.Endpoint]
+	if !ok {
+		leader = &clusterLeader{}
+		p.leaders[maraconf.Endpoint] = leader
+	}
+	return leader
+}
+
+func (p *endpointPool) succeeded(endpoint string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	health := p.get(endpoint)
+	health.Failures = 0
+	health.LastSuccessAt = time.Now()
+	health.CooldownUntil = time.Time{}
+}
+
+func (p *endpointPool) failed(maraconf configuration.Marathon, endpoint string, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	health := p.get(endpoint)
+	health.Failures++
+	health.LastError = err.Error()
+	health.LastFailureAt = time.Now()
+	health.CooldownUntil = health.LastFailureAt.Add(maraconf.Cooldown())
+
+	// A failing leader has likely lost its leadership, in every cluster
+	// sharing the endpoint
+	for _, leader := range p.leaders {
+		if endpoint == leader.endpoint {
+			*leader = clusterLeader{}
+		}
+	}
+	log.Printf("Marathon endpoint %s failed, cooling down for %s: %s\n", endpoint, maraconf.Cooldown(), err)
+}
+
+/*
+	Returns the endpoints in the order requests should try them: the leader
+	first, then the other available endpoints in the order of the
+	configuration, and last the ones cooling down, soonest available first.
+*/
+func (p *endpointPool) ordered(maraconf configuration.Marathon, client *http.Client) []string {
+	configured := maraconf.Endpoints()
+
+	p.lock.Lock()
+	refresh := time.Since(p.leader(maraconf).checkedAt) > leaderRefreshInterval
+	p.lock.Unlock()
+	if refresh && len(configured) > 1 {
+		endpoint := discoverLeader(configured, client)
+		p.lock.Lock()
+		*p.leader(maraconf) = clusterLeader{endpoint: endpoint, checkedAt: time.Now()}
+		p.lock.Unlock()
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	leader := p.leader(maraconf).endpoint
+	now := time.Now()
+	available := []string{}
+	cooling := []string{}
+	for _, endpoint := range configured {
+		if now.Before(p.get(endpoint).CooldownUntil) {
+			cooling = append(cooling, endpoint)
+		} else if endpoint == leader {
+			available = append([]string{endpoint}, available...)
+		} else {
+			available = append(available, endpoint)
+		}
+	}
+	sort.Stable(byCooldown{cooling, p})
+	return append(available, cooling...)
+}
+
+type byCooldown struct {
+	endpoints []string
+	pool      *endpointPool
+}
+
+func (s byCooldown) Len() int { return len(s.endpoints) }
+func (s byCooldown) Less(i, j int) bool {
+	return s.pool.health[s.endpoints[i]].CooldownUntil.Before(s.pool.health[s.endpoints[j]].CooldownUntil)
+}
+func (s byCooldown) Swap(i, j int) { s.endpoints[i], s.endpoints[j] = s.endpoints[j], s.endpoints[i] }
+
+type leaderResponse struct {
+	Leader string `json:"leader"`
+}
+
+/*
+	Asks every endpoint for the current leader concurrently and returns the
+	configured endpoint it is reachable at, using the first answer. Returns
+	an empty string when no endpoint answers or the leader is not one of the
+	configured endpoints.
+*/
+func discoverLeader(configured []string, client *http.Client) string {
+	answers := make(chan string, len(configured))
+	for _, endpoint := range configured {
+		go func(endpoint string) {
+			var response leaderResponse
+			if err := getJSON(client, endpoint+"/v2/leader", &response); err != nil {
+				answers <- ""
+				return
+			}
+			answers <- response.Leader
+		}(endpoint)
+	}
+
+	for range configured {
+		leader := <-answers
+		if leader == "" {
+			continue
+		}
+		for _, endpoint := range configured {
+			if parsed, err := url.Parse(endpoint); err == nil && parsed.Host == leader {
+				return endpoint
+			}
+		}
+		log.Printf("Marathon leader %s is not a configured endpoint\n", leader)
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package marathon
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+)
+
+// Serves an empty Marathon naming leader as the current leader
+func fakeMarathon(leader *string, fail *bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/leader":
+			io.WriteString(w, `{"leader": "`+*leader+`"}`)
+		case "/v2/tasks":
+			io.WriteString(w, `{"tasks": []}`)
+		case "/v2/apps":
+			io.WriteString(w, `{"apps": []}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func hostOf(server *httptest.Server) string {
+	parsed, _ := url.Parse(server.URL)
+	return parsed.Host
+}
+
+func TestEndpoints(t *testing.T) {
+	Convey("#FetchApps", t, func() {
+		marathonEndpoints = newEndpointPool()
+
+		var leader string
+		firstFails, secondFails := false, false
+		first := fakeMarathon(&leader, &firstFails)
+		defer first.Close()
+		second := fakeMarathon(&leader, &secondFails)
+		defer second.Close()
+		leader = hostOf(second)
+
+		maraconf := configuration.Marathon{Endpoint: first.URL + "," + second.URL}
+		client, _ := httpClient(maraconf)
+
+		Convey("should try the leader first", func() {
+			So(marathonEndpoints.ordered(maraconf, client), ShouldResemble, []string{second.URL, first.URL})
+			So(EndpointStatus(maraconf)[1].Leader, ShouldBeTrue)
+		})
+
+		Convey("should track the leader of every cluster", func() {
+			otherLeader := ""
+			otherFails := false
+			third := fakeMarathon(&otherLeader, &otherFails)
+			defer third.Close()
+			fourth := fakeMarathon(&otherLeader, &otherFails)
+			defer fourth.Close()
+			otherLeader = hostOf(third)
+			otherconf := configuration.Marathon{Endpoint: third.URL + "," + fourth.URL}
+
+			So(marathonEndpoints.ordered(maraconf, client), ShouldResemble, []string{second.URL, first.URL})
+			So(marathonEndpoints.ordered(otherconf, client), ShouldResemble, []string{third.URL, fourth.URL})
+
+			status := ClusterEndpointStatus([]configuration.Marathon{maraconf, otherconf})
+			So(len(status), ShouldEqual, 4)
+			leaders := []string{}
+			for _, health := range status {
+				if health.Leader {
+					leaders = append(leaders, health.Endpoint)
+				}
+			}
+			So(leaders, ShouldResemble, []string{second.URL, third.URL})
+
+			marathonEndpoints.failed(otherconf, third.URL, errors.New("unavailable"))
+			So(EndpointStatus(otherconf)[0].Leader, ShouldBeFalse)
+			So(EndpointStatus(maraconf)[1].Leader, ShouldBeTrue)
+		})
+
+		Convey("should keep the configured order without a known leader", func() {
+			leader = "elsewhere:8080"
+			So(marathonEndpoints.ordered(maraconf, client), ShouldResemble, []string{first.URL, second.URL})
+		})
+
+		Convey("should fail over and cool down failing endpoints", func() {
+			secondFails = true
+			apps, err := FetchApps(maraconf)
+			So(err, ShouldBeNil)
+			So(apps, ShouldNotBeNil)
+
+			status := EndpointStatus(maraconf)
+			So(status[0].Available, ShouldBeTrue)
+			So(status[0].Failures, ShouldEqual, 0)
+			So(status[1].Available, ShouldBeFalse)
+			So(status[1].Leader, ShouldBeFalse)
+			So(status[1].Failures, ShouldEqual, 1)
+			So(status[1].LastError, ShouldContainSubstring, "503")
+
+			secondFails = false
+			So(marathonEndpoints.ordered(maraconf, client), ShouldResemble, []string{first.URL, second.URL})
+		})
+
+		Convey("should still try endpoints cooling down when none is available", func() {
+			firstFails, secondFails = true, true
+			_, err := FetchApps(maraconf)
+			So(err, ShouldNotBeNil)
+
+			firstFails, secondFails = false, false
+			_, err = FetchApps(maraconf)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should time out hung endpoints", func() {
+			hung := make(chan bool)
+			stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-hung
+			}))
+			defer stalled.Close()
+			defer close(hung)
+
+			maraconf := configuration.Marathon{Endpoint: stalled.URL + "," + first.URL, RequestTimeout: 1}
+			_, err := FetchApps(maraconf)
+			So(err, ShouldBeNil)
+			So(EndpointStatus(maraconf)[0].Available, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,178 @@
+package marathon
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+)
+
+// Bounds of the delay between two connection attempts to the event stream
+const (
+	minStreamBackoff = 1 * time.Second
+	maxStreamBackoff = 30 * time.Second
+)
+
+// A single Server-Sent Event read from Marathon's /v2/events stream
+type StreamEvent struct {
+	// Value of the SSE "event" field, e.g. status_update_event
+	Event string
+	// Payload of the event, a JSON document
+	Data []byte
+}
+
+/*
+	ListenToEventStream connects to the /v2/events endpoint of Marathon and
+	forwards every received event to the events channel. When the stream
+	breaks, it reconnects to the next configured endpoint with an
+	exponential backoff. It returns once quit is closed or written to.
+
+	Parameters:
+		maraconf: Marathon configuration, all endpoints are tried in turn
+		events: channel receiving the parsed events
+		quit: channel used to stop listening
+*/
+func ListenToEventStream(maraconf configuration.Marathon, events chan<- StreamEvent, quit <-chan bool) {
+	endpoints := maraconf.Endpoints()
+	backoff := minStreamBackoff
+
+	for i := 0; ; i = (i + 1) % len(endpoints) {
+		started := time.Now()
+		// Without a timeout, the stream stays open as long as Marathon
+		// keeps it up
+		client, err := NewClient(maraconf, 0)
+		if err == nil {
+			err = readEventStream(client, endpoints[i], events, quit)
+		}
+		if err == errStreamClosed {
+			return
+		}
+		log.Printf("Marathon event stream %s disconnected: %s\n", endpoints[i], err)
+
+		// A stream which stayed up for a while is considered healthy,
+		// so the next failure starts over with the shortest delay
+		if time.Since(started) > maxStreamBackoff {
+			backoff = minStreamBackoff
+		}
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
+
+var errStreamClosed = errors.New("event stream closed")
+
+func readEventStream(client *http.Client, endpoint string, events chan<- StreamEvent, quit <-chan bool) error {
+	req, err := http.NewRequest("GET", endpoint+"/v2/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "text/event-stream")
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	log.Printf("Connected to Marathon event stream %s\n", endpoint)
+
+	// Closing the body from another goroutine unblocks the reader
+	done := make(chan struct{})
+	defer close(done)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-quit:
+			close(stopped)
+			response.Body.Close()
+		case <-done:
+		}
+	}()
+
+	err = parseEventStream(response.Body, func(event StreamEvent) {
+		select {
+		case events <- event:
+		case <-stopped:
+		}
+	})
+	select {
+	case <-stopped:
+		return errStreamClosed
+	default:
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	return err
+}
+
+/*
+	Reads Server-Sent Events frames from r and calls emit for each complete
+	event. Comments and the id and retry fields are ignored. Multiple data
+	lines of a single event are joined with a newline.
+*/
+func parseEventStream(r io.Reader, emit func(StreamEvent)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var eventType string
+	var data bytes.Buffer
+	hasData := false
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// An empty line dispatches the pending event
+		if line == "" {
+			if hasData {
+				emit(StreamEvent{Event: eventType, Data: append([]byte(nil), data.Bytes()...)})
+			}
+			eventType = ""
+			data.Reset()
+			hasData = false
+			continue
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if idx := strings.Index(line, ":"); idx >= 0 {
+			field = line[:idx]
+			value = strings.TrimPrefix(line[idx+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,44 @@
+package marathon
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseEventStream(t *testing.T) {
+	Convey("#parseEventStream", t, func() {
+		collect := func(stream string) []StreamEvent {
+			events := []StreamEvent{}
+			parseEventStream(strings.NewReader(stream), func(ev StreamEvent) {
+				events = append(events, ev)
+			})
+			return events
+		}
+
+		Convey("should parse event type and data", func() {
+			events := collect("event: status_update_event\ndata: {\"eventType\":\"status_update_event\"}\n\n")
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Event, ShouldEqual, "status_update_event")
+			So(string(events[0].Data), ShouldEqual, "{\"eventType\":\"status_update_event\"}")
+		})
+
+		Convey("should join multiple data lines", func() {
+			events := collect("data: {\ndata: }\n\n")
+			So(len(events), ShouldEqual, 1)
+			So(string(events[0].Data), ShouldEqual, "{\n}")
+		})
+
+		Convey("should ignore comments and events without data", func() {
+			events := collect(": keepalive\n\nevent: ping\n\nid: 1\ndata: a\n\n")
+			So(len(events), ShouldEqual, 1)
+			So(string(events[0].Data), ShouldEqual, "a")
+		})
+
+		Convey("should not emit an unterminated event", func() {
+			events := collect("data: partial\n")
+			So(len(events), ShouldEqual, 0)
+		})
+	})
+}
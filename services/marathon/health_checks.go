@@ -0,0 +1,87 @@
+package marathon
+
+// Health check protocols of Marathon
+const (
+	ProtocolHTTP       = "HTTP"
+	ProtocolHTTPS      = "HTTPS"
+	ProtocolTCP        = "TCP"
+	ProtocolCommand    = "COMMAND"
+	ProtocolMesosHTTP  = "MESOS_HTTP"
+	ProtocolMesosHTTPS = "MESOS_HTTPS"
+	ProtocolMesosTCP   = "MESOS_TCP"
+)
+
+// A health check of a Marathon app
+type HealthCheck struct {
+	// One of the Protocol constants, defaults to HTTP
+	Protocol string `json:"protocol"`
+	Path     string `json:"path"`
+	// Index of the checked port in the ports of the task, unless Port is
+	// set. Absent for COMMAND checks.
+	PortIndex              *int                `json:"portIndex,omitempty"`
+	Port                   int                 `json:"port,omitempty"`
+	GracePeriodSeconds     int                 `json:"gracePeriodSeconds"`
+	IntervalSeconds        int                 `json:"intervalSeconds"`
+	TimeoutSeconds         int                 `json:"timeoutSeconds"`
+	MaxConsecutiveFailures int                 `json:"maxConsecutiveFailures"`
+	Command                *HealthCheckCommand `json:"command,omitempty"`
+}
+
+type HealthCheckCommand struct {
+	Value string `json:"value"`
+}
+
+// Whether the check is an HTTP request
+func (check HealthCheck) IsHTTP() bool {
+	switch check.Protocol {
+	case ProtocolHTTP, ProtocolHTTPS, ProtocolMesosHTTP, ProtocolMesosHTTPS:
+		return true
+	}
+	return false
+}
+
+// Whether the check is made over TLS
+func (check HealthCheck) IsTLS() bool {
+	return check.Protocol == ProtocolHTTPS || check.Protocol == ProtocolMesosHTTPS
+}
+
+// Whether HAProxy can perform the check, which rules out commands
+func (check HealthCheck) IsNetwork() bool {
+	return check.IsHTTP() || check.Protocol == ProtocolTCP || check.Protocol == ProtocolMesosTCP
+}
+
+/*
+	Returns the port of the task the check is made against, false when the
+	check has no port or the task does not have it.
+*/
+func (check HealthCheck) TaskPort(task Task) (int, bool) {
+	if check.Port > 0 {
+		return check.Port, true
+	}
+	if check.PortIndex == nil || *check.PortIndex < 0 || *check.PortIndex >= len(task.Ports) {
+		return 0, false
+	}
+	return task.Ports[*check.PortIndex], true
+}
+
+// Returns the health checks of an app, with the default protocol filled in
+func parseHealthChecks(checks []HealthCheck) []HealthCheck {
+	result := []HealthCheck{}
+	for _, check := range checks {
+		if check.Protocol == "" {
+			check.Protocol = ProtocolHTTP
+		}
+		result = append(result, check)
+	}
+	return result
+}
+
+// Returns the path of the first HTTP health check
+func parseHealthCheckPath(checks []HealthCheck) string {
+	for _, check := range parseHealthChecks(checks) {
+		if check.IsHTTP() {
+			return check.Path
+		}
+	}
+	return ""
+}
@@ -1,9 +1,7 @@
 package marathon
 
 import (
-	"encoding/json"
 	"github.com/seomoz/roger-bamboo/configuration"
-	"io/ioutil"
 	"net/http"
 	"sort"
 	"strings"
@@ -11,20 +9,49 @@ import (
 
 // Describes an app process running
 type Task struct {
+	// Agent the task runs on
 	Host string
 	Port int
 	Ports []int
+	// IP address of the task, set with IP-per-task and container
+	// networking
+	IpAddress string
+	// The task is reached at IpAddress rather than Host, see Address
+	IpPerTask bool
+	// Ports of the task by the name given in the app definition
+	NamedPorts map[string]int `json:",omitempty"`
 }
 
 // An app may have multiple processes
 type App struct {
 	Id              string
 	EscapedId       string
+	// Name of the Marathon cluster the app runs on, see source.Marathon
+	Cluster         string
+	// Path of the first HTTP health check, see HealthChecks
 	HealthCheckPath string
+	HealthChecks    []HealthCheck
 	Tasks           []Task
         TcpPorts        map[string]string
 	ServicePort     int
 	Env             map[string]string
+	Labels          map[string]string
+
+	// Routing settings, read from the bamboo.* labels or the
+	// corresponding env vars
+	HttpPort        string
+	HttpPrefix      string
+	SessionAffinity bool
+
+	// Time of the last change to the app definition, ISO 8601 formatted
+	ConfigChangedAt string
+
+	// Ids of the deployments of the app in progress, only set with
+	// Marathon.EmbedDeployments
+	Deployments []string
+
+	// Invalid settings of the app, which have been dropped
+	Errors []AppError
 }
 
 type AppList []App
@@ -33,7 +60,11 @@ func (slice AppList) Len() int {
 	return len(slice)
 }
 
+// Apps of several clusters may share their id, see source.prefixed
 func (slice AppList) Less(i, j int) bool {
+	if slice[i].Id == slice[j].Id {
+		return slice[i].EscapedId < slice[j].EscapedId
+	}
 	return slice[i].Id < slice[j].Id
 }
 
@@ -43,10 +74,6 @@ func (slice AppList) Swap(i, j int) {
 
 type MarathonTaskList []MarathonTask
 
-type MarathonTasks struct {
-	Tasks MarathonTaskList `json:tasks`
-}
-
 type MarathonTask struct {
 	AppId        string
 	Id           string
@@ -56,6 +83,10 @@ type MarathonTask struct {
 	StartedAt    string
 	StagedAt     string
 	Version      string
+	// e.g. TASK_STAGING, TASK_RUNNING, TASK_KILLING
+	State              string
+	HealthCheckResults []HealthCheckResult
+	IpAddresses        []TaskIpAddress
 }
 
 func (slice MarathonTaskList) Len() int {
@@ -71,96 +102,93 @@ func (slice MarathonTaskList) Swap(i, j int) {
 }
 
 type MarathonApps struct {
-	Apps []MarathonApp `json:apps`
+	Apps []MarathonApp `json:"apps"`
 }
 
 type MarathonApp struct {
-	Id           string            `json:id`
-	HealthChecks []HealthChecks    `json:healthChecks`
-	Ports        []int             `json:ports`
-	Env          map[string]string `json:env`
+	Id           string            `json:"id"`
+	HealthChecks []HealthCheck     `json:"healthChecks"`
+	Ports        []int             `json:"ports"`
+	Env          map[string]string `json:"env"`
+	Labels       map[string]string `json:"labels"`
+	Version      string            `json:"version"`
+	VersionInfo  VersionInfo       `json:"versionInfo"`
+	// Networking of the app, see networking.go
+	PortDefinitions []PortDefinition `json:"portDefinitions"`
+	Container       *Container       `json:"container"`
+	IpAddress       *AppIpAddress    `json:"ipAddress"`
+	// Embedded with embed=apps.tasks
+	Tasks MarathonTaskList `json:"tasks"`
+	// Embedded with embed=apps.deployments
+	Deployments []Deployment `json:"deployments"`
 }
 
-type HealthChecks struct {
-	Path string `json:path`
+// A deployment of Marathon in progress
+type Deployment struct {
+	Id string `json:"id"`
 }
 
-func fetchMarathonApps(endpoint string) (map[string]MarathonApp, error) {
-	response, err := http.Get(endpoint + "/v2/apps")
-
-	if err != nil {
-		return nil, err
-	} else {
-		defer response.Body.Close()
-		var appResponse MarathonApps
-
-		contents, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			return nil, err
-		}
-
-		err = json.Unmarshal(contents, &appResponse)
-		if err != nil {
-			return nil, err
-		}
-
-		dataById := map[string]MarathonApp{}
-
-		for _, appConfig := range appResponse.Apps {
-			dataById[appConfig.Id] = appConfig
-		}
-
-		return dataById, nil
-	}
+type VersionInfo struct {
+	LastConfigChangeAt string `json:"lastConfigChangeAt"`
 }
 
-func fetchTasks(endpoint string) (map[string][]MarathonTask, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", endpoint+"/v2/tasks", nil)
-	req.Header.Add("Accept", "application/json")
-	response, err := client.Do(req)
+/*
+	Fetches the apps along with their tasks in a single request, so that
+	both come from the same state of Marathon.
 
-	var tasks MarathonTasks
+	Parameters:
+		embedDeployments: also embed the deployments in progress
+*/
+func fetchMarathonApps(client *http.Client, endpoint string, embedDeployments bool) (map[string]MarathonApp, error) {
+	url := endpoint + "/v2/apps?embed=apps.tasks"
+	if embedDeployments {
+		url += "&embed=apps.deployments"
+	}
 
-	if err != nil {
+	var appResponse MarathonApps
+	if err := getJSON(client, url, &appResponse); err != nil {
 		return nil, err
-	} else {
-		contents, err := ioutil.ReadAll(response.Body)
-		defer response.Body.Close()
-		if err != nil {
-			return nil, err
-		}
+	}
 
-		err = json.Unmarshal(contents, &tasks)
-		if err != nil {
-			return nil, err
-		}
+	dataById := map[string]MarathonApp{}
 
-		taskList := tasks.Tasks
-		sort.Sort(taskList)
+	for _, appConfig := range appResponse.Apps {
+		sort.Sort(appConfig.Tasks)
+		dataById[appConfig.Id] = appConfig
+	}
 
-		tasksById := map[string][]MarathonTask{}
-		for _, task := range taskList {
-			if tasksById[task.AppId] == nil {
-				tasksById[task.AppId] = []MarathonTask{}
-			}
-			tasksById[task.AppId] = append(tasksById[task.AppId], task)
-		}
+	return dataById, nil
+}
 
-		return tasksById, nil
+// Returns the tasks embedded in the apps, by app id
+func tasksByAppId(marathonApps map[string]MarathonApp) map[string][]MarathonTask {
+	tasksById := map[string][]MarathonTask{}
+	for appId, app := range marathonApps {
+		tasksById[appId] = app.Tasks
 	}
+	return tasksById
 }
 
-func createApps(tasksById map[string][]MarathonTask, marathonApps map[string]MarathonApp) AppList {
+/*
+	Creates an App for every Marathon app, including the ones without any
+	task left after filtering, so that templates can render maintenance
+	backends for them.
+*/
+func createApps(tasksById map[string][]MarathonTask, marathonApps map[string]MarathonApp, taskFilter string) AppList {
 
 	apps := AppList{}
 
-	for appId, tasks := range tasksById {
+	for appId := range marathonApps {
+		tasks := tasksById[appId]
 		simpleTasks := []Task{}
+		filter := appTaskFilter(marathonApps[appId], taskFilter)
 
 		for _, task := range tasks {
-			if len(task.Ports) > 0 {
-				simpleTasks = append(simpleTasks, Task{Host: task.Host, Port: task.Ports[0], Ports: task.Ports})
+			if !keepTask(filter, task, marathonApps[appId]) {
+				continue
+			}
+			if simpleTask, ok := newTask(task, marathonApps[appId]); ok {
+				simpleTasks = append(simpleTasks, simpleTask)
 			}
 		}
 
@@ -170,18 +198,6 @@ func createApps(tasksById map[string][]MarathonTask, marathonApps map[string]Mar
 			appPath = "/" + appId
 		}
 
-		// Create the TcpPorts value. If the environment
-		// definition for the app contains the key 'TCP_PORTS'
-		// then the value of the key is used to populate the
-		// TcpPorts field in the App struct. The value is
-		// assumed to be a JSON object in the format {"externalPort1": "PORTXX", "externalPort2": "2123"}
-		var tcp_ports map[string]string
-		if val, ok := marathonApps[appId].Env["TCP_PORTS"]; ok {
-			err := json.Unmarshal([]byte(val), &tcp_ports)
-			if err != nil {
-				panic(err)
-			}
-		}
 		app := App{
 			// Since Marathon 0.7, apps are namespaced with path
 			Id: appPath,
@@ -189,24 +205,59 @@ func createApps(tasksById map[string][]MarathonTask, marathonApps map[string]Mar
 			EscapedId:       strings.Replace(appId, "/", "::", -1),
 			Tasks:           simpleTasks,
 			HealthCheckPath: parseHealthCheckPath(marathonApps[appId].HealthChecks),
+			HealthChecks:    parseHealthChecks(marathonApps[appId].HealthChecks),
 			Env:             marathonApps[appId].Env,
-		        TcpPorts:        tcp_ports,
+			Labels:          marathonApps[appId].Labels,
+		}
+
+		// Create the TcpPorts value. If the labels or the
+		// environment definition for the app contain the key
+		// 'bamboo.tcp.ports' or 'TCP_PORTS' then the value of
+		// the key is used to populate the TcpPorts field in
+		// the App struct. The value is assumed to be a JSON
+		// object in the format {"externalPort1": "PORTXX", "externalPort2": "2123"}
+		// Invalid values are recorded in app.Errors instead.
+		ports := taskPorts(simpleTasks, marathonApps[appId])
+		app.TcpPorts = parseTcpPorts(&app, marathonApps[appId], ports)
+		app.HttpPort = parseHttpPort(&app, marathonApps[appId], ports)
+		app.HttpPrefix, _ = marathonApps[appId].routingValue(httpPrefixKey)
+		app.SessionAffinity = marathonApps[appId].routingFlag(sessionAffinityKey)
+
+		// Older Marathon versions only expose the version, which
+		// is the time of the last change of any kind
+		app.ConfigChangedAt = marathonApps[appId].VersionInfo.LastConfigChangeAt
+		if app.ConfigChangedAt == "" {
+			app.ConfigChangedAt = marathonApps[appId].Version
 		}
 
 		if len(marathonApps[appId].Ports) > 0 {
 			app.ServicePort = marathonApps[appId].Ports[0]
 		}
 
+		for _, deployment := range marathonApps[appId].Deployments {
+			app.Deployments = append(app.Deployments, deployment.Id)
+		}
+
 		apps = append(apps, app)
 	}
 	return apps
 }
 
-func parseHealthCheckPath(checks []HealthChecks) string {
-	if len(checks) > 0 {
-		return checks[0].Path
+/*
+	Creates the Apps of app definitions described the way Marathon describes
+	them, with their tasks embedded. Meant for sources other than Marathon,
+	so that their apps are read and validated like Marathon apps.
+*/
+func NewApps(marathonApps []MarathonApp, taskFilter string) AppList {
+	dataById := map[string]MarathonApp{}
+	for _, appConfig := range marathonApps {
+		sort.Sort(appConfig.Tasks)
+		dataById[appConfig.Id] = appConfig
 	}
-	return ""
+
+	apps := createApps(tasksByAppId(dataById), dataById, taskFilter)
+	sort.Sort(apps)
+	return apps
 }
 
 /*
@@ -214,36 +265,54 @@ func parseHealthCheckPath(checks []HealthChecks) string {
 	sub tasks information.
 
 	Parameters:
-		endpoint: Marathon HTTP endpoint, e.g. http://localhost:8080
+		maraconf: Marathon configuration
 */
 func FetchApps(maraconf configuration.Marathon) (AppList, error) {
+	definitions, err := FetchDefinitions(maraconf)
+	if err != nil {
+		return nil, err
+	}
+	return NewApps(definitions, maraconf.TaskFilter), nil
+}
+
+/*
+	Fetches the definitions of the apps of Marathon with their tasks
+	embedded, see NewApps.
+
+	Endpoints are tried in turn, the leader first, see endpointPool.ordered.
+	An endpoint failing a request is skipped for Marathon.Cooldown() unless
+	no other endpoint is available.
+*/
+func FetchDefinitions(maraconf configuration.Marathon) ([]MarathonApp, error) {
 
-	var applist AppList
+	var definitions []MarathonApp
 	var err error
 
-	// try all configured endpoints until one succeeds
-	for _, url := range maraconf.Endpoints() {
-		applist, err = _fetchApps(url)
+	client, err := httpClient(maraconf)
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range marathonEndpoints.ordered(maraconf, client) {
+		definitions, err = fetchDefinitions(client, url, maraconf)
 		if err == nil {
-			return applist, err
+			marathonEndpoints.succeeded(url)
+			return definitions, err
 		}
+		marathonEndpoints.failed(maraconf, url, err)
 	}
 	// return last error
 	return nil, err
 }
 
-func _fetchApps(url string) (AppList, error) {
-	tasks, err := fetchTasks(url)
+func fetchDefinitions(client *http.Client, url string, maraconf configuration.Marathon) ([]MarathonApp, error) {
+	marathonApps, err := fetchMarathonApps(client, url, maraconf.EmbedDeployments)
 	if err != nil {
 		return nil, err
 	}
 
-	marathonApps, err := fetchMarathonApps(url)
-	if err != nil {
-		return nil, err
+	definitions := []MarathonApp{}
+	for _, app := range marathonApps {
+		definitions = append(definitions, app)
 	}
-
-	apps := createApps(tasks, marathonApps)
-	sort.Sort(apps)
-	return apps, nil
+	return definitions, nil
 }
@@ -0,0 +1,62 @@
+package marathon
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+)
+
+const embeddedApps = `{"apps": [
+	{"id": "/web", "ports": [10000], "deployments": [{"id": "d1"}], "tasks": [
+		{"appId": "/web", "host": "b", "ports": [31001], "stagedAt": "2015-01-02T00:00:00.000Z", "state": "TASK_RUNNING"},
+		{"appId": "/web", "host": "a", "ports": [31000], "stagedAt": "2015-01-01T00:00:00.000Z", "state": "TASK_RUNNING"}
+	]},
+	{"id": "/idle", "ports": [10001], "tasks": []}
+]}`
+
+func TestFetchApps(t *testing.T) {
+	Convey("#FetchApps", t, func() {
+		marathonEndpoints = newEndpointPool()
+
+		var query []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v2/apps" {
+				http.NotFound(w, r)
+				return
+			}
+			query = r.URL.Query()["embed"]
+			io.WriteString(w, embeddedApps)
+		}))
+		defer server.Close()
+
+		Convey("should fetch apps with their tasks in a single request", func() {
+			apps, err := FetchApps(configuration.Marathon{Endpoint: server.URL})
+			So(err, ShouldBeNil)
+			So(query, ShouldResemble, []string{"apps.tasks"})
+			So(len(apps), ShouldEqual, 2)
+
+			So(apps[1].Id, ShouldEqual, "/web")
+			So(len(apps[1].Tasks), ShouldEqual, 2)
+			So(apps[1].Tasks[0].Host, ShouldEqual, "a")
+		})
+
+		Convey("should keep apps without tasks", func() {
+			apps, _ := FetchApps(configuration.Marathon{Endpoint: server.URL})
+			So(apps[0].Id, ShouldEqual, "/idle")
+			So(apps[0].Tasks, ShouldBeEmpty)
+			So(apps[0].ServicePort, ShouldEqual, 10001)
+		})
+
+		Convey("should embed deployments when asked to", func() {
+			apps, _ := FetchApps(configuration.Marathon{Endpoint: server.URL, EmbedDeployments: true})
+			So(query, ShouldResemble, []string{"apps.tasks", "apps.deployments"})
+			So(apps[1].Deployments, ShouldResemble, []string{"d1"})
+			So(apps[0].Deployments, ShouldBeNil)
+		})
+	})
+}
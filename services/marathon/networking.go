@@ -0,0 +1,148 @@
+package marathon
+
+// An IP address Marathon assigned to a task
+type TaskIpAddress struct {
+	IpAddress string `json:"ipAddress"`
+	Protocol  string `json:"protocol"`
+}
+
+// A port of an app using host networking
+type PortDefinition struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Name     string `json:"name"`
+}
+
+// A container port of an app, mapped to a host port in bridge networking
+type PortMapping struct {
+	ContainerPort int    `json:"containerPort"`
+	HostPort      int    `json:"hostPort"`
+	ServicePort   int    `json:"servicePort"`
+	Protocol      string `json:"protocol"`
+	Name          string `json:"name"`
+}
+
+type Container struct {
+	Docker *DockerContainer `json:"docker"`
+	// Set instead of Docker.PortMappings since Marathon 1.5
+	PortMappings []PortMapping `json:"portMappings"`
+}
+
+type DockerContainer struct {
+	Network      string        `json:"network"`
+	PortMappings []PortMapping `json:"portMappings"`
+}
+
+// IP-per-task settings of an app
+type AppIpAddress struct {
+	NetworkName string    `json:"networkName"`
+	Discovery   Discovery `json:"discovery"`
+}
+
+type Discovery struct {
+	Ports []DiscoveryPort `json:"ports"`
+}
+
+type DiscoveryPort struct {
+	Number   int    `json:"number"`
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+}
+
+// Returns the container port mappings of the app, if any
+func (app MarathonApp) portMappings() []PortMapping {
+	if app.Container == nil {
+		return nil
+	}
+	if len(app.Container.PortMappings) > 0 {
+		return app.Container.PortMappings
+	}
+	if app.Container.Docker != nil {
+		return app.Container.Docker.PortMappings
+	}
+	return nil
+}
+
+/*
+	Returns the names of the ports of the tasks, in the order of their
+	ports. Unnamed ports have an empty name.
+*/
+func (app MarathonApp) portNames() []string {
+	names := []string{}
+	if mappings := app.portMappings(); len(mappings) > 0 {
+		for _, mapping := range mappings {
+			names = append(names, mapping.Name)
+		}
+	} else if len(app.PortDefinitions) > 0 {
+		for _, definition := range app.PortDefinitions {
+			names = append(names, definition.Name)
+		}
+	} else if app.IpAddress != nil {
+		for _, port := range app.IpAddress.Discovery.Ports {
+			names = append(names, port.Name)
+		}
+	}
+	return names
+}
+
+/*
+	Returns the ports the tasks of an IP-per-task app listen on at their own
+	IP address: the container ports of the port mappings, or else the
+	discovery ports.
+*/
+func (app MarathonApp) containerPorts() []int {
+	ports := []int{}
+	if mappings := app.portMappings(); len(mappings) > 0 {
+		for _, mapping := range mappings {
+			ports = append(ports, mapping.ContainerPort)
+		}
+	} else if app.IpAddress != nil {
+		for _, port := range app.IpAddress.Discovery.Ports {
+			ports = append(ports, port.Number)
+		}
+	}
+	return ports
+}
+
+/*
+	Creates the Task of a Marathon task. Tasks with host ports are reached
+	at those ports on the agent. Tasks without any host port but an IP
+	address of their own are reached at their container ports on that
+	address. Returns false for tasks which cannot be reached at all.
+*/
+func newTask(task MarathonTask, app MarathonApp) (Task, bool) {
+	result := Task{Host: task.Host, Ports: task.Ports}
+	if len(task.IpAddresses) > 0 {
+		result.IpAddress = task.IpAddresses[0].IpAddress
+	}
+	if len(result.Ports) == 0 && result.IpAddress != "" {
+		result.Ports = app.containerPorts()
+		result.IpPerTask = true
+	}
+	if len(result.Ports) == 0 {
+		return result, false
+	}
+	result.Port = result.Ports[0]
+
+	for i, name := range app.portNames() {
+		if name == "" || i >= len(result.Ports) {
+			continue
+		}
+		if result.NamedPorts == nil {
+			result.NamedPorts = map[string]int{}
+		}
+		result.NamedPorts[name] = result.Ports[i]
+	}
+	return result, true
+}
+
+/*
+	Returns the address the task is reached at: its own IP address for
+	IP-per-task networking, the agent host otherwise.
+*/
+func (task Task) Address() string {
+	if task.IpPerTask {
+		return task.IpAddress
+	}
+	return task.Host
+}
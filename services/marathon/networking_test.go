@@ -0,0 +1,79 @@
+package marathon
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNetworking(t *testing.T) {
+	Convey("#newTask", t, func() {
+		decode := func(appJson string, taskJson string) (MarathonApp, MarathonTask) {
+			var app MarathonApp
+			var task MarathonTask
+			So(json.Unmarshal([]byte(appJson), &app), ShouldBeNil)
+			So(json.Unmarshal([]byte(taskJson), &task), ShouldBeNil)
+			return app, task
+		}
+
+		Convey("should name host ports after the port definitions", func() {
+			app, task := decode(`{"portDefinitions": [{"port": 10000, "name": "http"}, {"port": 10001}]}`,
+				`{"host": "agent", "ports": [31000, 31001]}`)
+			result, ok := newTask(task, app)
+			So(ok, ShouldBeTrue)
+			So(result.Address(), ShouldEqual, "agent")
+			So(result.Port, ShouldEqual, 31000)
+			So(result.NamedPorts, ShouldResemble, map[string]int{"http": 31000})
+		})
+
+		Convey("should reach bridged tasks at their host ports on the agent", func() {
+			app, task := decode(`{"container": {"docker": {"network": "BRIDGE", "portMappings": [
+					{"containerPort": 80, "hostPort": 0, "name": "web"},
+					{"containerPort": 9090, "hostPort": 0, "name": "admin"}]}}}`,
+				`{"host": "agent", "ports": [31000, 31001], "ipAddresses": [{"ipAddress": "172.17.0.2"}]}`)
+			result, _ := newTask(task, app)
+			So(result.IpAddress, ShouldEqual, "172.17.0.2")
+			So(result.IpPerTask, ShouldBeFalse)
+			So(result.Address(), ShouldEqual, "agent")
+			So(result.NamedPorts, ShouldResemble, map[string]int{"web": 31000, "admin": 31001})
+		})
+
+		Convey("should reach IP-per-task tasks at their container ports", func() {
+			app, task := decode(`{"container": {"portMappings": [{"containerPort": 8080, "name": "http"}]}}`,
+				`{"host": "agent", "ports": [], "ipAddresses": [{"ipAddress": "10.1.0.5", "protocol": "IPv4"}]}`)
+			result, ok := newTask(task, app)
+			So(ok, ShouldBeTrue)
+			So(result.Address(), ShouldEqual, "10.1.0.5")
+			So(result.Ports, ShouldResemble, []int{8080})
+			So(result.NamedPorts, ShouldResemble, map[string]int{"http": 8080})
+		})
+
+		Convey("should use the discovery ports of IP-per-task apps", func() {
+			app, task := decode(`{"ipAddress": {"discovery": {"ports": [{"number": 8443, "name": "https"}]}}}`,
+				`{"host": "agent", "ipAddresses": [{"ipAddress": "10.1.0.6"}]}`)
+			result, _ := newTask(task, app)
+			So(result.Address(), ShouldEqual, "10.1.0.6")
+			So(result.NamedPorts["https"], ShouldEqual, 8443)
+		})
+
+		Convey("should drop tasks which cannot be reached", func() {
+			app, task := decode(`{}`, `{"host": "agent"}`)
+			_, ok := newTask(task, app)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("#createApps with named ports", t, func() {
+		app := MarathonApp{Id: "/app",
+			PortDefinitions: []PortDefinition{{Name: "http"}, {Name: "admin"}},
+			Labels:          map[string]string{"bamboo.http.port": "http", "bamboo.tcp.ports": `{"3300": "admin", "3301": "metrics"}`}}
+		tasks := map[string][]MarathonTask{"/app": {{Host: "a", Ports: []int{1, 2}, State: "TASK_RUNNING"}}}
+		apps := createApps(tasks, map[string]MarathonApp{"/app": app}, "")
+
+		So(apps[0].HttpPort, ShouldEqual, "http")
+		So(apps[0].TcpPorts, ShouldBeNil)
+		So(len(apps[0].Errors), ShouldEqual, 1)
+		So(apps[0].Errors[0].Message, ShouldContainSubstring, "metrics")
+	})
+}
@@ -0,0 +1,67 @@
+package marathon
+
+import (
+	"strconv"
+)
+
+/*
+	Routing settings of an app can be given either as a Marathon label or as
+	an env var. Labels are namespaced under "bamboo." and take precedence
+	over the env var of the same setting, which is kept for apps deployed
+	before labels were supported.
+*/
+type routingKey struct {
+	Label string
+	Env   string
+}
+
+var (
+	// JSON object mapping external HAProxy ports to task ports
+	tcpPortsKey = routingKey{"bamboo.tcp.ports", "TCP_PORTS"}
+	// Task port receiving HTTP traffic, PORTn or a port number
+	httpPortKey = routingKey{"bamboo.http.port", "HTTP_PORT"}
+	// Path prefix routed to the app, defaults to the app id
+	httpPrefixKey = routingKey{"bamboo.http.prefix", "HTTP_PREFIX"}
+	// Enables cookie based session affinity
+	sessionAffinityKey = routingKey{"bamboo.session.affinity", "ENABLE_SESSION_AFFINITY"}
+)
+
+// Returns the value of a routing setting, the label winning over the env var
+func (app MarathonApp) routingValue(key routingKey) (string, bool) {
+	if value, ok := app.Labels[key.Label]; ok {
+		return value, true
+	}
+	value, ok := app.Env[key.Env]
+	return value, ok
+}
+
+// Returns the label or env var a routing setting is read from
+func (app MarathonApp) routingField(key routingKey) string {
+	if _, ok := app.Labels[key.Label]; ok {
+		return key.Label
+	}
+	return key.Env
+}
+
+/*
+	Returns whether a routing flag is enabled. Boolean label values are
+	parsed, any other non-empty value enables the flag. The env var keeps
+	its original meaning: any non-empty value, "false" included, enables it.
+*/
+func (app MarathonApp) routingFlag(key routingKey) bool {
+	if value, ok := app.Labels[key.Label]; ok {
+		if enabled, err := strconv.ParseBool(value); err == nil {
+			return enabled
+		}
+		return value != ""
+	}
+	return app.Env[key.Env] != ""
+}
+
+// Returns the label or env var the TCP ports of the app were read from
+func (app App) TcpPortsField() string {
+	if _, ok := app.Labels[tcpPortsKey.Label]; ok {
+		return tcpPortsKey.Label
+	}
+	return tcpPortsKey.Env
+}
@@ -0,0 +1,49 @@
+package marathon
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRouting(t *testing.T) {
+	Convey("#routingValue", t, func() {
+		Convey("should prefer the label, even when empty, over the env var", func() {
+			app := MarathonApp{Labels: map[string]string{"bamboo.http.prefix": ""},
+				Env: map[string]string{"HTTP_PREFIX": "/api"}}
+			value, ok := app.routingValue(httpPrefixKey)
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, "")
+			So(app.routingField(httpPrefixKey), ShouldEqual, "bamboo.http.prefix")
+		})
+
+		Convey("should fall back to the env var", func() {
+			app := MarathonApp{Env: map[string]string{"HTTP_PREFIX": "/api"}}
+			value, ok := app.routingValue(httpPrefixKey)
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, "/api")
+			So(app.routingField(httpPrefixKey), ShouldEqual, "HTTP_PREFIX")
+		})
+	})
+
+	Convey("#routingFlag", t, func() {
+		flag := func(labels map[string]string, env map[string]string) bool {
+			return MarathonApp{Labels: labels, Env: env}.routingFlag(sessionAffinityKey)
+		}
+
+		Convey("should parse boolean labels", func() {
+			So(flag(map[string]string{"bamboo.session.affinity": "true"}, nil), ShouldBeTrue)
+			So(flag(map[string]string{"bamboo.session.affinity": "false"}, nil), ShouldBeFalse)
+			So(flag(map[string]string{"bamboo.session.affinity": "yes"}, nil), ShouldBeTrue)
+			So(flag(map[string]string{"bamboo.session.affinity": ""},
+				map[string]string{"ENABLE_SESSION_AFFINITY": "1"}), ShouldBeFalse)
+		})
+
+		Convey("should enable the env var flag for any non-empty value", func() {
+			So(flag(nil, map[string]string{"ENABLE_SESSION_AFFINITY": "false"}), ShouldBeTrue)
+			So(flag(nil, map[string]string{"ENABLE_SESSION_AFFINITY": "0"}), ShouldBeTrue)
+			So(flag(nil, map[string]string{"ENABLE_SESSION_AFFINITY": ""}), ShouldBeFalse)
+			So(flag(nil, nil), ShouldBeFalse)
+		})
+	})
+}
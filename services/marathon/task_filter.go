@@ -0,0 +1,104 @@
+package marathon
+
+import (
+	"log"
+)
+
+// Policies deciding which tasks of an app are load balanced
+const (
+	// Only running tasks passing all of their health checks
+	TaskFilterHealthy = "healthy"
+	// Every running task, whatever its health
+	TaskFilterRunning = "running"
+	// Every task, including staging and killed ones
+	TaskFilterAll = "all"
+)
+
+// Name of the app env var overriding the task filter
+const taskFilterEnv = "TASK_FILTER"
+
+// Name of the app label overriding the task filter
+const taskFilterLabel = "bamboo.task.filter"
+
+type HealthCheckResult struct {
+	Alive               bool   `json:"alive"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	FirstSuccess        string `json:"firstSuccess"`
+	LastFailure         string `json:"lastFailure"`
+	LastSuccess         string `json:"lastSuccess"`
+}
+
+func isValidTaskFilter(filter string) bool {
+	switch filter {
+	case TaskFilterHealthy, TaskFilterRunning, TaskFilterAll:
+		return true
+	}
+	return false
+}
+
+/*
+	Returns the task filter of an app. The app label takes precedence over
+	the app env var, which takes precedence over the configured default.
+	Invalid values are logged and ignored.
+*/
+func appTaskFilter(app MarathonApp, defaultFilter string) string {
+	filter := TaskFilterRunning
+	if isValidTaskFilter(defaultFilter) {
+		filter = defaultFilter
+	}
+
+	for _, value := range []string{app.Env[taskFilterEnv], app.Labels[taskFilterLabel]} {
+		if value == "" {
+			continue
+		}
+		if isValidTaskFilter(value) {
+			filter = value
+		} else {
+			log.Printf("Ignoring invalid task filter %q of app %s\n", value, app.Id)
+		}
+	}
+	return filter
+}
+
+/*
+	A task is running when Marathon reports it as TASK_RUNNING. Marathon
+	versions which do not report the state only list staged and running
+	tasks, the latter having been started.
+*/
+func (task MarathonTask) isRunning() bool {
+	if task.State != "" {
+		return task.State == "TASK_RUNNING"
+	}
+	return task.StartedAt != ""
+}
+
+/*
+	A task is healthy when it is running and every health check of its app
+	reports it alive. Tasks of apps without health checks are healthy as
+	long as they run.
+*/
+func (task MarathonTask) isHealthy(app MarathonApp) bool {
+	if !task.isRunning() {
+		return false
+	}
+	if len(task.HealthCheckResults) < len(app.HealthChecks) {
+		return false
+	}
+	for _, result := range task.HealthCheckResults {
+		if !result.Alive {
+			return false
+		}
+	}
+	return true
+}
+
+func keepTask(filter string, task MarathonTask, app MarathonApp) bool {
+	switch filter {
+	case TaskFilterAll:
+		return true
+	case TaskFilterHealthy:
+		return task.isHealthy(app)
+	default:
+		return task.isRunning()
+	}
+}
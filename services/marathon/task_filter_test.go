@@ -0,0 +1,55 @@
+package marathon
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTaskFilter(t *testing.T) {
+	Convey("#createApps", t, func() {
+		app := MarathonApp{Id: "/app", HealthChecks: []HealthCheck{{Path: "/health"}}, Env: map[string]string{}}
+		tasks := []MarathonTask{
+			{AppId: "/app", Host: "healthy", Ports: []int{1}, State: "TASK_RUNNING",
+				HealthCheckResults: []HealthCheckResult{{Alive: true}}},
+			{AppId: "/app", Host: "unhealthy", Ports: []int{2}, State: "TASK_RUNNING",
+				HealthCheckResults: []HealthCheckResult{{Alive: false}}},
+			{AppId: "/app", Host: "unchecked", Ports: []int{3}, State: "TASK_RUNNING"},
+			{AppId: "/app", Host: "staging", Ports: []int{4}, State: "TASK_STAGING"},
+		}
+		hosts := func(apps AppList) []string {
+			result := []string{}
+			for _, task := range apps[0].Tasks {
+				result = append(result, task.Host)
+			}
+			return result
+		}
+		create := func(filter string) AppList {
+			return createApps(map[string][]MarathonTask{"/app": tasks}, map[string]MarathonApp{"/app": app}, filter)
+		}
+
+		Convey("should keep only running tasks by default", func() {
+			So(hosts(create("")), ShouldResemble, []string{"healthy", "unhealthy", "unchecked"})
+		})
+
+		Convey("should keep only healthy tasks", func() {
+			So(hosts(create(TaskFilterHealthy)), ShouldResemble, []string{"healthy"})
+		})
+
+		Convey("should keep every task", func() {
+			So(len(hosts(create(TaskFilterAll))), ShouldEqual, 4)
+		})
+
+		Convey("should let the app label override the env var and the default", func() {
+			app.Env[taskFilterEnv] = TaskFilterAll
+			app.Labels = map[string]string{taskFilterLabel: TaskFilterHealthy}
+			So(hosts(create(TaskFilterRunning)), ShouldResemble, []string{"healthy"})
+		})
+
+		Convey("should treat tasks without state as running once started", func() {
+			task := MarathonTask{StartedAt: "2015-01-01T00:00:00.000Z"}
+			So(task.isRunning(), ShouldBeTrue)
+			So(MarathonTask{}.isRunning(), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,155 @@
+package marathon
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Valid task port descriptions, see getTaskPort in the template package
+var taskPortRegex = regexp.MustCompile("^PORT([\\d]+)$")
+var numPortRegex = regexp.MustCompile("^[\\d]+$")
+
+/*
+	Describes an invalid routing setting of an app. The offending setting is
+	dropped, the rest of the app is still load balanced.
+*/
+type AppError struct {
+	AppId string
+	// Label or env var holding the invalid value
+	Field   string
+	Message string
+}
+
+// Returns the validation errors of all the apps
+func (slice AppList) Errors() []AppError {
+	errors := []AppError{}
+	for _, app := range slice {
+		errors = append(errors, app.Errors...)
+	}
+	return errors
+}
+
+func (app *App) addError(field string, format string, args ...interface{}) {
+	app.Errors = append(app.Errors, AppError{
+		AppId:   app.Id,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+/*
+	Parses the TCP ports setting of an app. When the value is not a valid
+	JSON object of external ports to task port descriptions, an error is
+	recorded and no TCP listener is created for the app.
+*/
+func parseTcpPorts(app *App, marathonApp MarathonApp, ports taskPortSet) map[string]string {
+	value, ok := marathonApp.routingValue(tcpPortsKey)
+	if !ok {
+		return nil
+	}
+	field := marathonApp.routingField(tcpPortsKey)
+
+	var tcpPorts map[string]string
+	if err := json.Unmarshal([]byte(value), &tcpPorts); err != nil {
+		app.addError(field, "invalid JSON object: %s", err)
+		return nil
+	}
+
+	// Sorted so that errors come in a stable order across fetches
+	externalPorts := make([]string, 0, len(tcpPorts))
+	for externalPort := range tcpPorts {
+		externalPorts = append(externalPorts, externalPort)
+	}
+	sort.Strings(externalPorts)
+
+	valid := true
+	for _, externalPort := range externalPorts {
+		taskPort := tcpPorts[externalPort]
+		if port, err := strconv.Atoi(externalPort); err != nil || port < 1 || port > 65535 {
+			app.addError(field, "invalid external port %q", externalPort)
+			valid = false
+		}
+		if err := validateTaskPort(taskPort, ports); err != nil {
+			app.addError(field, "external port %s: %s", externalPort, err)
+			valid = false
+		}
+	}
+	if !valid {
+		return nil
+	}
+	return tcpPorts
+}
+
+/*
+	Parses the HTTP port setting of an app. An invalid value is recorded
+	and dropped, leaving the HTTP backend of the app without servers.
+*/
+func parseHttpPort(app *App, marathonApp MarathonApp, ports taskPortSet) string {
+	value, _ := marathonApp.routingValue(httpPortKey)
+	if value == "" {
+		return ""
+	}
+	if err := validateTaskPort(value, ports); err != nil {
+		app.addError(marathonApp.routingField(httpPortKey), "%s", err)
+		return ""
+	}
+	return value
+}
+
+// The ports of the tasks of an app task port descriptions may refer to
+type taskPortSet struct {
+	// Number of ports every task has, bounding the PORTn descriptions
+	Count int
+	// Names of the ports every task has
+	Names map[string]bool
+}
+
+/*
+	Returns the ports every task of an app has. Falls back to the ports of
+	the app definition when it has no tasks.
+*/
+func taskPorts(tasks []Task, marathonApp MarathonApp) taskPortSet {
+	count := 0
+	if len(tasks) == 0 {
+		count = len(marathonApp.Ports)
+		if count == 0 {
+			count = len(marathonApp.containerPorts())
+		}
+	} else {
+		count = len(tasks[0].Ports)
+		for _, task := range tasks[1:] {
+			if len(task.Ports) < count {
+				count = len(task.Ports)
+			}
+		}
+	}
+
+	names := map[string]bool{}
+	for i, name := range marathonApp.portNames() {
+		if name != "" && i < count {
+			names[name] = true
+		}
+	}
+	return taskPortSet{Count: count, Names: names}
+}
+
+// Checks a task port description against the ports of the tasks
+func validateTaskPort(description string, ports taskPortSet) error {
+	if match := taskPortRegex.FindStringSubmatch(description); match != nil {
+		index, err := strconv.Atoi(match[1])
+		if err != nil || index >= ports.Count {
+			return fmt.Errorf("%s refers to a port the app does not have (%d ports)", description, ports.Count)
+		}
+		return nil
+	}
+	if numPortRegex.MatchString(description) {
+		return nil
+	}
+	if ports.Names[description] {
+		return nil
+	}
+	return fmt.Errorf("invalid port description %q, expected PORTn, a port number or the name of a port of the app", description)
+}
@@ -0,0 +1,49 @@
+package marathon
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidation(t *testing.T) {
+	Convey("#createApps", t, func() {
+		tasks := map[string][]MarathonTask{
+			"/good": {{Host: "a", Ports: []int{1, 2}, State: "TASK_RUNNING"}},
+			"/bad":  {{Host: "b", Ports: []int{3}, State: "TASK_RUNNING"}},
+		}
+		create := func(badEnv map[string]string) (App, App) {
+			apps := createApps(tasks, map[string]MarathonApp{
+				"/good": {Id: "/good", Env: map[string]string{"TCP_PORTS": `{"3300": "PORT1"}`, "HTTP_PORT": "PORT0"}},
+				"/bad":  {Id: "/bad", Env: badEnv},
+			}, "")
+			if apps[0].Id == "/good" {
+				return apps[0], apps[1]
+			}
+			return apps[1], apps[0]
+		}
+
+		Convey("should record malformed TCP_PORTS without affecting other apps", func() {
+			good, bad := create(map[string]string{"TCP_PORTS": "{not json"})
+			So(good.TcpPorts, ShouldResemble, map[string]string{"3300": "PORT1"})
+			So(good.Errors, ShouldBeEmpty)
+			So(bad.TcpPorts, ShouldBeNil)
+			So(len(bad.Errors), ShouldEqual, 1)
+			So(bad.Errors[0].AppId, ShouldEqual, "/bad")
+			So(bad.Errors[0].Field, ShouldEqual, "TCP_PORTS")
+		})
+
+		Convey("should reject port indexes the tasks do not have", func() {
+			_, bad := create(map[string]string{"TCP_PORTS": `{"3301": "PORT1"}`, "HTTP_PORT": "PORT3"})
+			So(bad.TcpPorts, ShouldBeNil)
+			So(bad.HttpPort, ShouldEqual, "")
+			So(len(AppList{bad}.Errors()), ShouldEqual, 2)
+		})
+
+		Convey("should reject invalid external ports", func() {
+			_, bad := create(map[string]string{"TCP_PORTS": `{"http": "PORT0"}`})
+			So(bad.TcpPorts, ShouldBeNil)
+			So(bad.Errors[0].Message, ShouldContainSubstring, "external port")
+		})
+	})
+}
@@ -0,0 +1,288 @@
+package mesos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+// Paths of the master state, the latter for Mesos versions before 1.0
+var statePaths = []string{"/master/state", "/state.json"}
+
+var errNotFound = errors.New("not found")
+
+// The parts of the state of a Mesos master describing the running tasks
+type State struct {
+	Pid        string      `json:"pid"`
+	Leader     string      `json:"leader"`
+	Slaves     []Slave     `json:"slaves"`
+	Frameworks []Framework `json:"frameworks"`
+}
+
+type Slave struct {
+	Id       string `json:"id"`
+	Hostname string `json:"hostname"`
+}
+
+type Framework struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Tasks []Task `json:"tasks"`
+}
+
+type Task struct {
+	Id        string     `json:"id"`
+	SlaveId   string     `json:"slave_id"`
+	State     string     `json:"state"`
+	Resources Resources  `json:"resources"`
+	Discovery *Discovery `json:"discovery"`
+	Statuses  []Status   `json:"statuses"`
+}
+
+type Resources struct {
+	// Port ranges, e.g. "[31000-31001, 31005-31005]"
+	Ports string `json:"ports"`
+}
+
+type Discovery struct {
+	Ports struct {
+		Ports []marathon.DiscoveryPort `json:"ports"`
+	} `json:"ports"`
+}
+
+type Status struct {
+	State string `json:"state"`
+	// Only set for tasks with Mesos health checks
+	Healthy *bool `json:"healthy"`
+
+	ContainerStatus struct {
+		NetworkInfos []struct {
+			IpAddresses []struct {
+				IpAddress string `json:"ip_address"`
+			} `json:"ip_addresses"`
+		} `json:"network_infos"`
+	} `json:"container_status"`
+}
+
+/*
+	Reads the tasks Marathon launched from the state of the leading Mesos
+	master, as a degraded replacement of Marathon. Mesos does not know the
+	definitions of the apps, so the tasks are put on the definitions last
+	read from Marathon: apps keep their env vars, labels and health checks,
+	and apps without any running task are kept as well. Tasks of apps
+	created since are ignored.
+
+	Mesos does not know the results of Marathon's health checks either.
+	Tasks Marathon last reported keep their results, other tasks are only
+	healthy when Mesos reports them so.
+
+	The masters are requested with the credentials and TLS settings of
+	Marathon, as on DC/OS where both sit behind the same authentication.
+
+	Parameters:
+		maraconf: configuration of the Marathon cluster, see Marathon.Mesos
+		definitions: the app definitions last read from Marathon, with
+			their tasks
+*/
+func FetchApps(maraconf configuration.Marathon, definitions []marathon.MarathonApp) (marathon.AppList, error) {
+	client, err := marathon.NewClient(maraconf, maraconf.Timeout())
+	if err != nil {
+		return nil, err
+	}
+	state, err := fetchLeaderState(maraconf.Mesos, client)
+	if err != nil {
+		return nil, err
+	}
+	return marathon.NewApps(overlayTasks(state, maraconf.Mesos.Framework(), definitions), maraconf.TaskFilter), nil
+}
+
+// Returns the state of the first master reporting itself as the leader
+func fetchLeaderState(mesosconf configuration.Mesos, client *http.Client) (State, error) {
+	var err error
+	for _, endpoint := range mesosconf.Endpoints() {
+		var state State
+		state, err = fetchState(client, strings.TrimRight(endpoint, "/"))
+		if err != nil {
+			continue
+		}
+		if state.Leader != "" && state.Leader != state.Pid {
+			err = fmt.Errorf("Mesos master %s is not the leader %s", endpoint, state.Leader)
+			continue
+		}
+		return state, nil
+	}
+	return State{}, err
+}
+
+func fetchState(client *http.Client, endpoint string) (State, error) {
+	var state State
+	var err error
+	for _, path := range statePaths {
+		if err = getJSON(client, endpoint+path, &state); err != errNotFound {
+			return state, err
+		}
+	}
+	return state, fmt.Errorf("no state found on Mesos master %s", endpoint)
+}
+
+func getJSON(client *http.Client, location string, v interface{}) error {
+	response, err := client.Get(location)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", location, response.Status)
+	}
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, v)
+}
+
+/*
+	Returns copies of the definitions whose tasks are replaced with the
+	tasks of the frameworks with the given name
+*/
+func overlayTasks(state State, frameworkName string, definitions []marathon.MarathonApp) []marathon.MarathonApp {
+	hosts := map[string]string{}
+	for _, slave := range state.Slaves {
+		hosts[slave.Id] = slave.Hostname
+	}
+
+	tasksById := map[string][]Task{}
+	for _, framework := range state.Frameworks {
+		if framework.Name != frameworkName {
+			continue
+		}
+		for _, task := range framework.Tasks {
+			id := appId(task.Id)
+			tasksById[id] = append(tasksById[id], task)
+		}
+	}
+
+	apps := []marathon.MarathonApp{}
+	for _, definition := range definitions {
+		// Apps of older Marathon versions have ids without slashes
+		id := definition.Id
+		if !strings.HasPrefix(id, "/") {
+			id = "/" + id
+		}
+		known := map[string]marathon.MarathonTask{}
+		for _, task := range definition.Tasks {
+			known[task.Id] = task
+		}
+
+		app := definition
+		app.Tasks = marathon.MarathonTaskList{}
+		for _, task := range tasksById[id] {
+			app.Tasks = append(app.Tasks, mergeTask(newTask(task, hosts[task.SlaveId]), known, task, len(definition.HealthChecks)))
+		}
+		delete(tasksById, id)
+		apps = append(apps, app)
+	}
+	if len(tasksById) > 0 {
+		log.Printf("Ignoring the Mesos tasks of %d apps unknown to Marathon\n", len(tasksById))
+	}
+	return apps
+}
+
+/*
+	Completes a task read from Mesos with what Marathon last reported about
+	it, or with the health Mesos reports
+*/
+func mergeTask(result marathon.MarathonTask, known map[string]marathon.MarathonTask, task Task, healthChecks int) marathon.MarathonTask {
+	if previous, ok := known[result.Id]; ok {
+		result.AppId = previous.AppId
+		result.ServicePorts = previous.ServicePorts
+		result.StagedAt = previous.StagedAt
+		result.StartedAt = previous.StartedAt
+		result.Version = previous.Version
+		result.HealthCheckResults = previous.HealthCheckResults
+		return result
+	}
+	if len(task.Statuses) > 0 {
+		if healthy := task.Statuses[len(task.Statuses)-1].Healthy; healthy != nil {
+			for i := 0; i < healthChecks; i++ {
+				result.HealthCheckResults = append(result.HealthCheckResults, marathon.HealthCheckResult{Alive: *healthy})
+			}
+		}
+	}
+	return result
+}
+
+/*
+	Returns the id of the app of a task. Marathon names tasks after the
+	path of their app with its slashes replaced by underscores, followed by
+	a dot and the unique id of the task, e.g. "group_web.2f0b7c4a-..." or
+	"group_web.instance-2f0b7c4a-..._app.1".
+*/
+func appId(taskId string) string {
+	name := taskId
+	if i := strings.Index(name, ".instance-"); i >= 0 {
+		name = name[:i]
+	} else if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	return "/" + strings.Replace(name, "_", "/", -1)
+}
+
+func newTask(task Task, host string) marathon.MarathonTask {
+	result := marathon.MarathonTask{Id: task.Id, Host: host, State: task.State}
+
+	// Discovery ports keep the order of the ports of the app, which the
+	// port ranges of the resources lose
+	ranges := parsePortRanges(task.Resources.Ports)
+	if task.Discovery != nil && len(task.Discovery.Ports.Ports) == len(ranges) {
+		for _, port := range task.Discovery.Ports.Ports {
+			result.Ports = append(result.Ports, port.Number)
+		}
+	} else {
+		result.Ports = ranges
+	}
+
+	if len(task.Statuses) > 0 {
+		latest := task.Statuses[len(task.Statuses)-1]
+		for _, network := range latest.ContainerStatus.NetworkInfos {
+			for _, address := range network.IpAddresses {
+				result.IpAddresses = append(result.IpAddresses, marathon.TaskIpAddress{IpAddress: address.IpAddress})
+			}
+		}
+	}
+	return result
+}
+
+// Expands port ranges such as "[31000-31001, 31005-31005]"
+func parsePortRanges(ranges string) []int {
+	ports := []int{}
+	for _, portRange := range strings.Split(strings.Trim(ranges, "[] "), ",") {
+		bounds := strings.SplitN(strings.TrimSpace(portRange), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+		for port := first; port <= last; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
@@ -0,0 +1,173 @@
+package mesos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+const leaderState = `{
+  "pid": "master@10.0.0.1:5050",
+  "leader": "master@10.0.0.1:5050",
+  "slaves": [{"id": "S1", "hostname": "agent1"}, {"id": "S2", "hostname": "agent2"}],
+  "frameworks": [
+    {
+      "id": "F1",
+      "name": "marathon",
+      "tasks": [
+        {
+          "id": "group_web.2f0b7c4a-1111",
+          "slave_id": "S1",
+          "state": "TASK_RUNNING",
+          "resources": {"ports": "[31005-31005, 31000-31000]"},
+          "labels": [{"key": "bamboo.http.port", "value": "http"}],
+          "discovery": {"ports": {"ports": [
+            {"number": 31005, "name": "http", "protocol": "tcp"},
+            {"number": 31000, "name": "admin", "protocol": "tcp"}
+          ]}}
+        },
+        {
+          "id": "group_web.instance-2f0b7c4a-2222._app.1",
+          "slave_id": "S2",
+          "state": "TASK_RUNNING",
+          "resources": {"ports": "[31010-31011]"},
+          "statuses": [{"state": "TASK_RUNNING", "healthy": true}]
+        },
+        {
+          "id": "group_web.5555",
+          "slave_id": "S2",
+          "state": "TASK_RUNNING",
+          "resources": {"ports": "[31030-31031]"}
+        },
+        {
+          "id": "created.6666",
+          "slave_id": "S1",
+          "state": "TASK_RUNNING",
+          "resources": {"ports": "[31040-31040]"}
+        },
+        {
+          "id": "cache.3333",
+          "slave_id": "S2",
+          "state": "TASK_KILLED",
+          "resources": {"ports": "[31020-31020]"}
+        }
+      ]
+    },
+    {
+      "id": "F2",
+      "name": "chronos",
+      "tasks": [{"id": "job.4444", "slave_id": "S1", "state": "TASK_RUNNING"}]
+    }
+  ]
+}`
+
+func fakeMaster(state string, legacy bool) *httptest.Server {
+	path := "/master/state"
+	if legacy {
+		path = "/state.json"
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(state))
+	}))
+}
+
+func TestFetchApps(t *testing.T) {
+	Convey("#FetchApps", t, func() {
+		follower := fakeMaster(`{"pid": "master@10.0.0.2:5050", "leader": "master@10.0.0.1:5050"}`, false)
+		defer follower.Close()
+		leader := fakeMaster(leaderState, true)
+		defer leader.Close()
+		maraconf := configuration.Marathon{TaskFilter: "healthy",
+			Mesos: configuration.Mesos{Endpoint: follower.URL + "," + leader.URL}}
+
+		definitions := []marathon.MarathonApp{
+			{
+				Id:           "/group/web",
+				Env:          map[string]string{"HTTP_PORT": "http"},
+				HealthChecks: []marathon.HealthCheck{{Protocol: "HTTP", Path: "/health"}},
+				PortDefinitions: []marathon.PortDefinition{
+					{Port: 10000, Protocol: "tcp", Name: "http"},
+					{Port: 10001, Protocol: "tcp", Name: "admin"},
+				},
+				Tasks: marathon.MarathonTaskList{{Id: "group_web.2f0b7c4a-1111", Host: "gone", State: "TASK_RUNNING",
+					HealthCheckResults: []marathon.HealthCheckResult{{Alive: true}}}},
+			},
+			{Id: "cache"},
+			{Id: "/idle"},
+		}
+
+		Convey("should put the tasks of the leading master on the app definitions", func() {
+			apps, err := FetchApps(maraconf, definitions)
+			So(err, ShouldBeNil)
+			So(len(apps), ShouldEqual, 3)
+			So(apps[0].Id, ShouldEqual, "/cache")
+			So(len(apps[0].Tasks), ShouldEqual, 0)
+			So(apps[2].Id, ShouldEqual, "/idle")
+
+			web := apps[1]
+			So(web.Id, ShouldEqual, "/group/web")
+			So(web.Env, ShouldResemble, map[string]string{"HTTP_PORT": "http"})
+			So(web.HealthCheckPath, ShouldEqual, "/health")
+			So(web.HttpPort, ShouldEqual, "http")
+			So(len(web.Tasks), ShouldEqual, 2)
+			So(web.Tasks[0].Host, ShouldEqual, "agent1")
+			So(web.Tasks[0].Ports, ShouldResemble, []int{31005, 31000})
+			So(web.Tasks[0].NamedPorts["admin"], ShouldEqual, 31000)
+			So(web.Tasks[1].Ports, ShouldResemble, []int{31010, 31011})
+		})
+
+		Convey("should not change the definitions", func() {
+			FetchApps(maraconf, definitions)
+			So(definitions[0].Tasks[0].Host, ShouldEqual, "gone")
+		})
+
+		Convey("should fail without any leading master", func() {
+			maraconf.Mesos.Endpoint = follower.URL
+			_, err := FetchApps(maraconf, definitions)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFetchAppsAuthentication(t *testing.T) {
+	Convey("#FetchApps", t, func() {
+		master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "token=secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(leaderState))
+		}))
+		defer master.Close()
+
+		Convey("should authenticate to the masters as to Marathon", func() {
+			maraconf := configuration.Marathon{Token: "secret", Mesos: configuration.Mesos{Endpoint: master.URL}}
+			_, err := FetchApps(maraconf, nil)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestAppId(t *testing.T) {
+	Convey("#appId", t, func() {
+		So(appId("web.2f0b7c4a-1111"), ShouldEqual, "/web")
+		So(appId("group_web.2f0b7c4a-1111"), ShouldEqual, "/group/web")
+		So(appId("group_web.instance-2f0b7c4a._app.1"), ShouldEqual, "/group/web")
+	})
+}
+
+func TestParsePortRanges(t *testing.T) {
+	Convey("#parsePortRanges", t, func() {
+		So(parsePortRanges("[31000-31002, 31005-31005]"), ShouldResemble, []int{31000, 31001, 31002, 31005})
+		So(parsePortRanges(""), ShouldResemble, []int{})
+	})
+}
@@ -0,0 +1,138 @@
+package ports
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+// Describes an external TCP port claimed by more than one app, or by an app
+// other than the one it is reserved for
+type Conflict struct {
+	Port string
+	// Ids of every app claiming the port
+	Apps []string
+	// Id of the app keeping the port, empty when no claiming app may use it
+	Winner string
+	Reason string
+}
+
+/*
+	Resolve detects the conflicting external TCP ports of apps and removes
+	them from every app but the winning one. A reserved port goes to the app
+	it is reserved for, other apps are denied it. Otherwise the current
+	owner of the port, as per the configuration HAProxy runs, keeps it, as
+	the other apps introduced the conflict. Without a current owner, e.g.
+	after a restart, the app whose definition has been unchanged for the
+	longest time keeps the port, then the first app by id, which every
+	Bamboo instance decides alike. Losing apps get an error recorded.
+
+	Parameters:
+		apps: apps with their claimed TcpPorts, sorted by id
+		reservations: app ids by reserved external port, may be nil
+		owners: app ids by external port in the applied configuration, may be nil
+*/
+func Resolve(apps marathon.AppList, reservations map[string]string, owners map[string]string) (marathon.AppList, []Conflict) {
+	claims := map[string][]int{}
+	for i, app := range apps {
+		for port := range app.TcpPorts {
+			claims[port] = append(claims[port], i)
+		}
+	}
+
+	ports := make([]string, 0, len(claims))
+	for port := range claims {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	conflicts := []Conflict{}
+	for _, port := range ports {
+		claimants := claims[port]
+		owner, reserved := reservations[port]
+		if len(claimants) < 2 && (!reserved || apps[claimants[0]].Id == owner) {
+			continue
+		}
+
+		conflict := Conflict{Port: port, Apps: []string{}}
+		winner := -1
+		if reserved {
+			conflict.Reason = fmt.Sprintf("reserved for %s", owner)
+			for _, i := range claimants {
+				if apps[i].Id == owner {
+					winner = i
+				}
+			}
+		} else {
+			for _, i := range claimants {
+				if apps[i].Id == owners[port] {
+					winner = i
+				}
+			}
+			if winner >= 0 {
+				conflict.Reason = fmt.Sprintf("used by %s", apps[winner].Id)
+			} else {
+				for _, i := range claimants {
+					if winner < 0 || deployedBefore(apps[i], apps[winner]) {
+						winner = i
+					}
+				}
+				conflict.Reason = fmt.Sprintf("used by %s, whose definition is older", apps[winner].Id)
+			}
+		}
+
+		for _, i := range claimants {
+			conflict.Apps = append(conflict.Apps, apps[i].Id)
+			if i != winner {
+				dropPort(&apps[i], port, conflict.Reason)
+			}
+		}
+		if winner >= 0 {
+			conflict.Winner = apps[winner].Id
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return apps, conflicts
+}
+
+/*
+	Compares the times of the last change to the definitions, as Marathon
+	reports them to every instance. Apps with an unknown definition time
+	come last, ties are broken by id.
+*/
+func deployedBefore(a marathon.App, b marathon.App) bool {
+	if a.ConfigChangedAt != b.ConfigChangedAt {
+		if a.ConfigChangedAt == "" || b.ConfigChangedAt == "" {
+			return b.ConfigChangedAt == ""
+		}
+		return a.ConfigChangedAt < b.ConfigChangedAt
+	}
+	return a.Id < b.Id
+}
+
+func dropPort(app *marathon.App, port string, reason string) {
+	tcpPorts := map[string]string{}
+	for external, task := range app.TcpPorts {
+		if external != port {
+			tcpPorts[external] = task
+		}
+	}
+	app.TcpPorts = tcpPorts
+	app.Errors = append(app.Errors, marathon.AppError{
+		AppId:   app.Id,
+		Field:   app.TcpPortsField(),
+		Message: fmt.Sprintf("external port %s is %s", port, reason),
+	})
+}
+
+// Returns the app ids by external TCP port actually in use
+func Used(apps marathon.AppList) map[string]string {
+	used := map[string]string{}
+	for _, app := range apps {
+		for port := range app.TcpPorts {
+			used[port] = app.Id
+		}
+	}
+	return used
+}
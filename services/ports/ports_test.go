@@ -0,0 +1,54 @@
+package ports
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+func TestResolve(t *testing.T) {
+	Convey("#Resolve", t, func() {
+		apps := marathon.AppList{
+			{Id: "/a", ConfigChangedAt: "2015-02-01T00:00:00.000Z", TcpPorts: map[string]string{"3300": "PORT0", "3301": "PORT1"}},
+			{Id: "/b", ConfigChangedAt: "2015-01-01T00:00:00.000Z", TcpPorts: map[string]string{"3300": "PORT0"}},
+			{Id: "/c", TcpPorts: map[string]string{"3302": "PORT0"}},
+		}
+
+		Convey("should give a contested port to the app with the oldest definition", func() {
+			resolved, conflicts := Resolve(apps, nil, nil)
+			So(len(conflicts), ShouldEqual, 1)
+			So(conflicts[0].Port, ShouldEqual, "3300")
+			So(conflicts[0].Apps, ShouldResemble, []string{"/a", "/b"})
+			So(conflicts[0].Winner, ShouldEqual, "/b")
+			So(resolved[0].TcpPorts, ShouldResemble, map[string]string{"3301": "PORT1"})
+			So(resolved[0].Errors[0].Field, ShouldEqual, "TCP_PORTS")
+			So(resolved[1].TcpPorts, ShouldResemble, map[string]string{"3300": "PORT0"})
+		})
+
+		Convey("should leave a contested port to its current owner", func() {
+			resolved, conflicts := Resolve(apps, nil, map[string]string{"3300": "/a", "3302": "/c"})
+			So(len(conflicts), ShouldEqual, 1)
+			So(conflicts[0].Winner, ShouldEqual, "/a")
+			So(resolved[0].TcpPorts, ShouldResemble, map[string]string{"3300": "PORT0", "3301": "PORT1"})
+			So(resolved[1].TcpPorts, ShouldBeEmpty)
+		})
+
+		Convey("should break ties by id", func() {
+			apps[0].ConfigChangedAt = apps[1].ConfigChangedAt
+			_, conflicts := Resolve(apps, nil, map[string]string{"3300": "/gone"})
+			So(conflicts[0].Winner, ShouldEqual, "/a")
+		})
+
+		Convey("should give a reserved port to its owner only", func() {
+			resolved, conflicts := Resolve(apps, map[string]string{"3300": "/a", "3302": "/other"}, map[string]string{"3300": "/b"})
+			So(len(conflicts), ShouldEqual, 2)
+			So(conflicts[0].Winner, ShouldEqual, "/a")
+			So(conflicts[1].Winner, ShouldEqual, "")
+			So(resolved[1].TcpPorts, ShouldBeEmpty)
+			So(resolved[2].TcpPorts, ShouldBeEmpty)
+			So(Used(resolved), ShouldResemble, map[string]string{"3300": "/a", "3301": "/a"})
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package ports
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+/*
+	Port reservations are stored in ZooKeeper as one node per external port
+	under the configured reservation path, the node data being the id of
+	the app the port is reserved for.
+*/
+
+// Returns the app ids by reserved external port
+func Reservations(conn *zk.Conn, path string) (map[string]string, error) {
+	reservations := map[string]string{}
+
+	exists, _, err := conn.Exists(path)
+	if err != nil || !exists {
+		return reservations, err
+	}
+
+	ports, _, err := conn.Children(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, port := range ports {
+		appId, _, err := conn.Get(path + "/" + port)
+		if err != nil {
+			return nil, err
+		}
+		reservations[port] = string(appId)
+	}
+	return reservations, nil
+}
+
+// Reserves an external port for an app, creating the nodes with the ACL.
+// Fails when the port is already reserved.
+func Reserve(conn *zk.Conn, path string, acl []zk.ACL, port string, appId string) error {
+	if err := validatePort(port); err != nil {
+		return err
+	}
+	if appId == "" {
+		return errors.New("an app id is required")
+	}
+	if err := ensurePathExists(conn, path, acl); err != nil {
+		return err
+	}
+	_, err := conn.Create(path+"/"+port, []byte(appId), 0, acl)
+	return err
+}
+
+// Releases a reserved external port
+func Release(conn *zk.Conn, path string, port string) error {
+	if err := validatePort(port); err != nil {
+		return err
+	}
+	return conn.Delete(path+"/"+port, -1)
+}
+
+func validatePort(port string) error {
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 || strconv.Itoa(number) != port {
+		return errors.New("invalid port " + port)
+	}
+	return nil
+}
+
+func ensurePathExists(conn *zk.Conn, path string, acl []zk.ACL) error {
+	exists, _, err := conn.Exists(path)
+	if err != nil || exists {
+		return err
+	}
+	_, err = conn.Create(path, []byte{}, 0, acl)
+	return err
+}
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	conf "github.com/seomoz/roger-bamboo/configuration"
+)
+
+// How long a blocking query waits for changes, and the delay before
+// retrying a failed one
+const (
+	consulWatchWait       = 5 * time.Minute
+	consulWatchRetryDelay = 5 * time.Second
+)
+
+/*
+	Stores every service as a Consul KV key, made of Consul.Prefix and the
+	escaped app id, holding the rule, see encodeRule.
+*/
+type ConsulStore struct {
+	consulConf conf.Consul
+	client     *http.Client
+}
+
+func NewConsulStore(consulConf conf.Consul) *ConsulStore {
+	return &ConsulStore{consulConf: consulConf, client: &http.Client{Timeout: consulConf.Timeout()}}
+}
+
+type consulKeyValue struct {
+	Key         string
+	Value       string
+	ModifyIndex uint64
+}
+
+func (s *ConsulStore) All() (map[string]Service, error) {
+	kvs, _, err := s.list(0)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := s.consulConf.KeyPrefix()
+	services := map[string]Service{}
+	for _, kv := range kvs {
+		appId, err := unescapeSlashes(strings.TrimPrefix(kv.Key, prefix))
+		if err != nil || appId == "" {
+			continue
+		}
+		services[appId] = decodeRule(appId, consulValue(kv), consulVersion(kv))
+	}
+	return services, nil
+}
+
+/*
+	Lists the keys under the prefix. With a non zero index, blocks until
+	the keys change after this index, or consulWatchWait elapsed.
+*/
+func (s *ConsulStore) list(index uint64) ([]consulKeyValue, uint64, error) {
+	query := url.Values{"recurse": {"true"}}
+	client := s.client
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWatchWait.String())
+		client = &http.Client{Timeout: consulWatchWait + s.consulConf.Timeout(), Transport: s.client.Transport}
+	}
+
+	var kvs []consulKeyValue
+	response, err := doJSON(client, "GET", s.location(s.consulConf.KeyPrefix(), query), s.header(), nil, &kvs)
+	if isNotFound(err) {
+		// No key under the prefix
+		err = nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return kvs, consulIndex(response), nil
+}
+
+func (s *ConsulStore) Get(appId string) (Service, error) {
+	kv, err := s.get(appId)
+	if err != nil {
+		return Service{}, err
+	}
+	return decodeRule(appId, consulValue(kv), consulVersion(kv)), nil
+}
+
+func (s *ConsulStore) get(appId string) (consulKeyValue, error) {
+	var kvs []consulKeyValue
+	_, err := doJSON(s.client, "GET", s.location(s.key(appId), nil), s.header(), nil, &kvs)
+	if isNotFound(err) || (err == nil && len(kvs) == 0) {
+		return consulKeyValue{}, ErrNotFound
+	}
+	if err != nil {
+		return consulKeyValue{}, err
+	}
+	return kvs[0], nil
+}
+
+// Puts the key with a check-and-set index of 0, as long as it does not exist
+func (s *ConsulStore) Create(service Service) error {
+	index, err := s.put(service, 0)
+	if err == nil && index == 0 {
+		return ErrExists
+	}
+	return err
+}
+
+/*
+	Puts the key with the modify index of the version as check-and-set
+	index. For AnyVersion, with the index it was read with, so that a key
+	deleted meanwhile is not created again, reading it again when it
+	changed meanwhile.
+*/
+func (s *ConsulStore) Update(service Service) (string, error) {
+	if service.Version != AnyVersion {
+		cas, err := consulIndexOf(service.Version)
+		if err != nil {
+			return "", err
+		}
+		index, err := s.put(service, cas)
+		if err == nil && index == 0 {
+			return "", s.failure(service.Id)
+		}
+		return strconv.FormatUint(index, 10), err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		kv, err := s.get(service.Id)
+		if err != nil {
+			return "", err
+		}
+		index, err := s.put(service, kv.ModifyIndex)
+		if err != nil {
+			return "", err
+		}
+		if index > 0 {
+			return strconv.FormatUint(index, 10), nil
+		}
+	}
+	return "", fmt.Errorf("service %s keeps changing, try again", service.Id)
+}
+
+type consulTxnOperation struct {
+	KV consulTxnKV
+}
+
+type consulTxnKV struct {
+	Verb  string
+	Key   string
+	Value string
+	Index uint64
+}
+
+type consulTxnResponse struct {
+	Results []struct {
+		KV consulKeyValue
+	}
+}
+
+/*
+	Puts the key with a check-and-set index in a transaction, whose result
+	holds the new modify index of the key. Returns 0 when the check failed.
+*/
+func (s *ConsulStore) put(service Service, cas uint64) (uint64, error) {
+	operations := []consulTxnOperation{{KV: consulTxnKV{
+		Verb:  "cas",
+		Key:   s.key(service.Id),
+		Value: base64.StdEncoding.EncodeToString([]byte(encodeRule(service))),
+		Index: cas,
+	}}}
+	var response consulTxnResponse
+	_, err := doJSON(s.client, "PUT", s.endpoint("/v1/txn", nil), s.header(), operations, &response)
+	if status, ok := err.(statusError); ok && status.Status == http.StatusConflict {
+		// Rolled back
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(response.Results) == 0 {
+		return 0, fmt.Errorf("no result of the Consul transaction for %s", service.Id)
+	}
+	return response.Results[0].KV.ModifyIndex, nil
+}
+
+// Deletes the key with the modify index of the version as check-and-set index
+func (s *ConsulStore) Delete(appId string, version string) error {
+	if version == AnyVersion {
+		if _, err := s.get(appId); err != nil {
+			return err
+		}
+		_, err := doJSON(s.client, "DELETE", s.location(s.key(appId), nil), s.header(), nil, nil)
+		return err
+	}
+
+	cas, err := consulIndexOf(version)
+	if err != nil {
+		return err
+	}
+	var succeeded bool
+	query := url.Values{"cas": {strconv.FormatUint(cas, 10)}}
+	_, err = doJSON(s.client, "DELETE", s.location(s.key(appId), query), s.header(), nil, &succeeded)
+	if err == nil && !succeeded {
+		return s.failure(appId)
+	}
+	return err
+}
+
+// Tells why a check-and-set failed
+func (s *ConsulStore) failure(appId string) error {
+	if _, err := s.get(appId); err != nil {
+		return err
+	}
+	return ErrConflict
+}
+
+/*
+	Watches the keys under the prefix with blocking queries, notifying an
+	event whenever their index changes.
+*/
+func (s *ConsulStore) Watch(quit <-chan bool) (<-chan Event, error) {
+	_, index, err := s.list(0)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		for {
+			_, next, err := s.list(index)
+			select {
+			case <-quit:
+				return
+			default:
+			}
+
+			if err != nil {
+				log.Printf("Consul watch failed, retrying in %s: %s\n", consulWatchRetryDelay, err)
+				select {
+				case <-quit:
+					return
+				case <-time.After(consulWatchRetryDelay):
+				}
+				continue
+			}
+
+			// The index goes backwards when the servers are restored,
+			// list again without blocking then
+			changed := next != index
+			if next < index {
+				next = 0
+			}
+			index = next
+			if !changed {
+				continue
+			}
+			select {
+			case events <- Event{EventType: "change"}:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (s *ConsulStore) key(appId string) string {
+	return s.consulConf.KeyPrefix() + escapeSlashes(appId)
+}
+
+// Returns the URL of a key, escaping the escaped app ids once more
+func (s *ConsulStore) location(key string, query url.Values) string {
+	return s.endpoint("/v1/kv/"+key, query)
+}
+
+// Returns the URL of an API path in the configured datacenter
+func (s *ConsulStore) endpoint(path string, query url.Values) string {
+	if s.consulConf.Datacenter != "" {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("dc", s.consulConf.Datacenter)
+	}
+	location := url.URL{Path: path, RawQuery: query.Encode()}
+	return strings.TrimRight(s.consulConf.Endpoint, "/") + location.String()
+}
+
+func (s *ConsulStore) header() http.Header {
+	header := http.Header{}
+	if s.consulConf.Token != "" {
+		header.Set("X-Consul-Token", s.consulConf.Token)
+	}
+	return header
+}
+
+func consulValue(kv consulKeyValue) string {
+	value, _ := base64.StdEncoding.DecodeString(kv.Value)
+	return string(value)
+}
+
+func consulVersion(kv consulKeyValue) string {
+	return strconv.FormatUint(kv.ModifyIndex, 10)
+}
+
+// Returns the modify index of a version
+func consulIndexOf(version string) (uint64, error) {
+	index, err := strconv.ParseUint(version, 10, 64)
+	if err != nil || index == 0 {
+		// A check-and-set index of 0 would create the key instead
+		return 0, ErrConflict
+	}
+	return index, nil
+}
+
+func consulIndex(response *http.Response) uint64 {
+	index, _ := strconv.ParseUint(response.Header.Get("X-Consul-Index"), 10, 64)
+	return index
+}
+
+func isNotFound(err error) bool {
+	status, ok := err.(statusError)
+	return ok && status.Status == http.StatusNotFound
+}
@@ -0,0 +1,398 @@
+package service
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	conf "github.com/seomoz/roger-bamboo/configuration"
+)
+
+// Delay before reconnecting a broken watch
+const etcdWatchRetryDelay = 5 * time.Second
+
+/*
+	Stores every service as an etcd v3 key, made of Etcd.Prefix and the
+	escaped app id, holding the rule, see encodeRule. Requests go through
+	the JSON gateway of etcd, trying the endpoints in turn.
+*/
+type EtcdStore struct {
+	etcdConf conf.Etcd
+	client   *http.Client
+
+	tokenLock sync.Mutex
+	token     string
+}
+
+func NewEtcdStore(etcdConf conf.Etcd) *EtcdStore {
+	return &EtcdStore{etcdConf: etcdConf, client: &http.Client{Timeout: etcdConf.Timeout()}}
+}
+
+type etcdKeyValue struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision,omitempty"`
+}
+
+type etcdRangeRequest struct {
+	Key      string `json:"key"`
+	RangeEnd string `json:"range_end,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdCompare struct {
+	Key            string `json:"key"`
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	CreateRevision string `json:"create_revision,omitempty"`
+	ModRevision    string `json:"mod_revision,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare            `json:"compare"`
+	Success []map[string]interface{} `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Header struct {
+		// Revision of the store after the transaction
+		Revision string `json:"revision"`
+	} `json:"header"`
+	Succeeded bool `json:"succeeded"`
+}
+
+func (s *EtcdStore) All() (map[string]Service, error) {
+	prefix := s.etcdConf.KeyPrefix()
+	var response etcdRangeResponse
+	err := s.post("/v3/kv/range", etcdRangeRequest{Key: encode(prefix), RangeEnd: encode(prefixEnd(prefix))}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	services := map[string]Service{}
+	for _, kv := range response.Kvs {
+		key, value := decode(kv.Key), decode(kv.Value)
+		appId, err := unescapeSlashes(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			continue
+		}
+		services[appId] = decodeRule(appId, value, kv.ModRevision)
+	}
+	return services, nil
+}
+
+func (s *EtcdStore) Get(appId string) (Service, error) {
+	var response etcdRangeResponse
+	if err := s.post("/v3/kv/range", etcdRangeRequest{Key: encode(s.key(appId))}, &response); err != nil {
+		return Service{}, err
+	}
+	if len(response.Kvs) == 0 {
+		return Service{}, ErrNotFound
+	}
+	kv := response.Kvs[0]
+	return decodeRule(appId, decode(kv.Value), kv.ModRevision), nil
+}
+
+// Puts the key in a transaction, as long as it does not exist yet
+func (s *EtcdStore) Create(service Service) error {
+	key := encode(s.key(service.Id))
+	response, err := s.txn(etcdCreated(key, "EQUAL"), etcdPut(key, encodeRule(service)))
+	if err == nil && !response.Succeeded {
+		return ErrExists
+	}
+	return err
+}
+
+/*
+	Puts the key in a transaction, as long as it exists and was last
+	modified at the revision of the version. The put key is last modified
+	at the revision of the transaction.
+*/
+func (s *EtcdStore) Update(service Service) (string, error) {
+	key := encode(s.key(service.Id))
+	compare, err := etcdVersioned(key, service.Version)
+	if err != nil {
+		return "", err
+	}
+	response, err := s.txn(compare, etcdPut(key, encodeRule(service)))
+	if err != nil {
+		return "", err
+	}
+	if !response.Succeeded {
+		return "", s.failure(service.Id)
+	}
+	return response.Header.Revision, nil
+}
+
+// Deletes the key in a transaction, with the same conditions as Update
+func (s *EtcdStore) Delete(appId string, version string) error {
+	key := encode(s.key(appId))
+	compare, err := etcdVersioned(key, version)
+	if err != nil {
+		return err
+	}
+	response, err := s.txn(compare, map[string]interface{}{"request_delete_range": etcdRangeRequest{Key: key}})
+	if err == nil && !response.Succeeded {
+		return s.failure(appId)
+	}
+	return err
+}
+
+/*
+	Compares the creation revision of the key to 0, which it equals when
+	the key does not exist.
+*/
+func etcdCreated(key string, result string) etcdCompare {
+	return etcdCompare{Key: key, Target: "CREATE", Result: result, CreateRevision: "0"}
+}
+
+/*
+	Compares the modification revision of the key to the version, or checks
+	that the key exists for AnyVersion.
+*/
+func etcdVersioned(key string, version string) (etcdCompare, error) {
+	if version == AnyVersion {
+		return etcdCreated(key, "GREATER"), nil
+	}
+	if revision, err := strconv.ParseInt(version, 10, 64); err != nil || revision <= 0 {
+		// No key was ever modified at this revision
+		return etcdCompare{}, ErrConflict
+	}
+	return etcdCompare{Key: key, Target: "MOD", Result: "EQUAL", ModRevision: version}, nil
+}
+
+// Tells why a versioned transaction failed
+func (s *EtcdStore) failure(appId string) error {
+	if _, err := s.Get(appId); err != nil {
+		return err
+	}
+	return ErrConflict
+}
+
+func etcdPut(key string, value string) map[string]interface{} {
+	return map[string]interface{}{"request_put": etcdKeyValue{Key: key, Value: encode(value)}}
+}
+
+// Runs the operation when the comparison succeeds
+func (s *EtcdStore) txn(compare etcdCompare, operation map[string]interface{}) (etcdTxnResponse, error) {
+	request := etcdTxnRequest{
+		Compare: []etcdCompare{compare},
+		Success: []map[string]interface{}{operation},
+	}
+	var response etcdTxnResponse
+	err := s.post("/v3/kv/txn", request, &response)
+	return response, err
+}
+
+/*
+	Streams the changes of the keys under the prefix. The stream is
+	reconnected whenever it breaks, notifying an event once connected
+	again since changes may have been missed meanwhile.
+*/
+func (s *EtcdStore) Watch(quit <-chan bool) (<-chan Event, error) {
+	events := make(chan Event)
+	go func() {
+		reconnected := false
+		for {
+			err := s.watch(events, reconnected, quit)
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			log.Printf("etcd watch broke, reconnecting in %s: %v\n", etcdWatchRetryDelay, err)
+			reconnected = true
+			select {
+			case <-quit:
+				return
+			case <-time.After(etcdWatchRetryDelay):
+			}
+		}
+	}()
+	return events, nil
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Created bool `json:"created"`
+		Events  []struct {
+			Type string `json:"type"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (s *EtcdStore) watch(events chan<- Event, reconnected bool, quit <-chan bool) error {
+	prefix := s.etcdConf.KeyPrefix()
+	request := map[string]interface{}{
+		"create_request": etcdRangeRequest{Key: encode(prefix), RangeEnd: encode(prefixEnd(prefix))},
+	}
+	body, _ := json.Marshal(request)
+
+	var err error
+	for _, endpoint := range s.etcdConf.Endpoints() {
+		var req *http.Request
+		req, err = http.NewRequest("POST", strings.TrimRight(endpoint, "/")+"/v3/watch", strings.NewReader(string(body)))
+		if err != nil {
+			return err
+		}
+		var token string
+		if token, err = s.authToken(endpoint); err != nil {
+			continue
+		} else if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+
+		// The stream lasts as long as the watch, without any timeout
+		var response *http.Response
+		response, err = (&http.Client{Transport: s.client.Transport}).Do(req)
+		if err != nil {
+			continue
+		}
+		stop := make(chan bool)
+		go func() {
+			select {
+			case <-quit:
+				response.Body.Close()
+			case <-stop:
+			}
+		}()
+		err = s.readWatch(response, events, reconnected, quit)
+		close(stop)
+		response.Body.Close()
+		return err
+	}
+	return err
+}
+
+func (s *EtcdStore) readWatch(response *http.Response, events chan<- Event, reconnected bool, quit <-chan bool) error {
+	if response.StatusCode != http.StatusOK {
+		s.resetToken()
+		return statusError{response.Request.URL.String(), response.StatusCode, ""}
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(response.Body))
+	for {
+		var message etcdWatchResponse
+		if err := decoder.Decode(&message); err != nil {
+			return err
+		}
+		if message.Error != nil {
+			return statusError{response.Request.URL.String(), response.StatusCode, message.Error.Message}
+		}
+
+		eventType := ""
+		if message.Result.Created && reconnected {
+			eventType = "RECONNECTED"
+		} else if len(message.Result.Events) > 0 {
+			eventType = message.Result.Events[0].Type
+			if eventType == "" {
+				eventType = "PUT"
+			}
+		}
+		if eventType == "" {
+			continue
+		}
+		select {
+		case events <- Event{EventType: eventType}:
+		case <-quit:
+			return nil
+		}
+	}
+}
+
+func (s *EtcdStore) key(appId string) string {
+	return s.etcdConf.KeyPrefix() + escapeSlashes(appId)
+}
+
+// Posts a request to the endpoints in turn, until one answers
+func (s *EtcdStore) post(path string, request interface{}, result interface{}) error {
+	var err error
+	for _, endpoint := range s.etcdConf.Endpoints() {
+		header := http.Header{}
+		if token, tokenErr := s.authToken(endpoint); tokenErr != nil {
+			err = tokenErr
+			continue
+		} else if token != "" {
+			header.Set("Authorization", token)
+		}
+
+		_, err = doJSON(s.client, "POST", strings.TrimRight(endpoint, "/")+path, header, request, result)
+		if err == nil {
+			return nil
+		}
+		if status, ok := err.(statusError); ok {
+			// Tokens expire, authenticate again on the next request
+			if status.Status == http.StatusUnauthorized || strings.Contains(status.Body, "token") {
+				s.resetToken()
+			}
+			// The endpoint answered, other ones would answer the same
+			if status.Status < 500 {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// Returns the authentication token, authenticating when needed
+func (s *EtcdStore) authToken(endpoint string) (string, error) {
+	if s.etcdConf.User == "" {
+		return "", nil
+	}
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+	if s.token != "" {
+		return s.token, nil
+	}
+
+	var response struct {
+		Token string `json:"token"`
+	}
+	request := map[string]string{"name": s.etcdConf.User, "password": s.etcdConf.Password}
+	_, err := doJSON(s.client, "POST", strings.TrimRight(endpoint, "/")+"/v3/auth/authenticate", nil, request, &response)
+	if err != nil {
+		return "", err
+	}
+	s.token = response.Token
+	return s.token, nil
+}
+
+func (s *EtcdStore) resetToken() {
+	s.tokenLock.Lock()
+	s.token = ""
+	s.tokenLock.Unlock()
+}
+
+// Returns the end of the range of the keys starting with a prefix
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// Every key
+	return "\x00"
+}
+
+func encode(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func decode(value string) string {
+	decoded, _ := base64.StdEncoding.DecodeString(value)
+	return string(decoded)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// The HTTP status of a failed request to etcd or Consul
+type statusError struct {
+	Location string
+	Status   int
+	Body     string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d %s", e.Location, e.Status, e.Body)
+}
+
+/*
+	Sends a request, encoding body as JSON unless it is nil or raw bytes,
+	and decodes the JSON response into result unless it is nil.
+*/
+func doJSON(client *http.Client, method string, location string, header http.Header, body interface{}, result interface{}) (*http.Response, error) {
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(body)
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, location, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return response, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return response, statusError{location, response.StatusCode, string(bytes.TrimSpace(contents))}
+	}
+	if result == nil {
+		return response, nil
+	}
+	return response, json.Unmarshal(contents, result)
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+	Structured routing rules of a service. A request is routed to the
+	service when it matches every kind of rule set, and any value of a
+	kind, e.g. any of the hosts and any of the path prefixes.
+*/
+type Routing struct {
+	// Host names, "*.example.com" matching any subdomain
+	Hosts []string `json:",omitempty"`
+	// Path prefixes, e.g. "/api"
+	PathPrefixes []string `json:",omitempty"`
+	// Headers to match, all of them
+	Headers []HeaderMatch `json:",omitempty"`
+
+	// Removes the matched path prefix from the forwarded requests
+	StripPrefix bool `json:",omitempty"`
+	// Replaces a path prefix of the forwarded requests, after StripPrefix
+	Rewrite *PathRewrite `json:",omitempty"`
+	// Timeouts of the backend
+	Timeouts *Timeouts `json:",omitempty"`
+	// Redirects the requests instead of forwarding them
+	Redirect *Redirect `json:",omitempty"`
+}
+
+type HeaderMatch struct {
+	Name string
+	// Matches any value when empty
+	Value string `json:",omitempty"`
+}
+
+type PathRewrite struct {
+	From string
+	To   string
+}
+
+// HAProxy times, e.g. "30s" or "500ms"
+type Timeouts struct {
+	Connect string `json:",omitempty"`
+	Server  string `json:",omitempty"`
+}
+
+type Redirect struct {
+	Location string
+	// 301, 302, 303, 307 or 308. Defaults to 302.
+	Code int `json:",omitempty"`
+	// Location only replaces the scheme and host, keeping the path
+	Prefix bool `json:",omitempty"`
+}
+
+// Characters HAProxy splits, escapes, quotes or comments its lines with
+const haproxySyntax = " \t\r\n\\#'\""
+
+var (
+	hostRegex       = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*(:[0-9]+)?$`)
+	headerNameRegex = regexp.MustCompile("^[A-Za-z0-9!$%&*+.^_`|~-]+$")
+	timeRegex       = regexp.MustCompile(`^[0-9]+(us|ms|s|m|h|d)?$`)
+	redirectCodes   = map[int]bool{301: true, 302: true, 303: true, 307: true, 308: true}
+)
+
+/*
+	Checks that the service has either a raw ACL or routing rules, which
+	end up in the HAProxy configuration as they are.
+*/
+func (s Service) Validate() error {
+	if strings.TrimSpace(s.Id) == "" {
+		return errors.New("Marathon ID is required")
+	}
+	if s.Routing == nil {
+		if strings.TrimSpace(s.Acl) == "" {
+			return errors.New("either Acl or Routing is required")
+		}
+		if strings.ContainsAny(s.Acl, "\r\n") {
+			return errors.New("Acl must be a single line")
+		}
+		return nil
+	}
+	if s.Acl != "" {
+		return errors.New("Acl and Routing cannot be both set")
+	}
+	return s.Routing.validate()
+}
+
+func (r *Routing) validate() error {
+	if len(r.Hosts) == 0 && len(r.PathPrefixes) == 0 && len(r.Headers) == 0 {
+		return errors.New("Routing requires Hosts, PathPrefixes or Headers")
+	}
+	for _, host := range r.Hosts {
+		if !hostRegex.MatchString(host) {
+			return fmt.Errorf("invalid host %q", host)
+		}
+	}
+	for _, prefix := range r.PathPrefixes {
+		if err := validatePath("path prefix", prefix); err != nil {
+			return err
+		}
+	}
+	for _, header := range r.Headers {
+		if !headerNameRegex.MatchString(header.Name) {
+			return fmt.Errorf("invalid header name %q", header.Name)
+		}
+		if strings.ContainsAny(header.Value, haproxySyntax) {
+			return fmt.Errorf("header value %q cannot hold spaces, quotes, backslashes or #", header.Value)
+		}
+	}
+
+	if r.StripPrefix && len(r.PathPrefixes) == 0 {
+		return errors.New("StripPrefix requires PathPrefixes")
+	}
+	if r.Rewrite != nil {
+		if err := validatePath("rewritten path", r.Rewrite.From); err != nil {
+			return err
+		}
+		if err := validatePath("rewriting path", r.Rewrite.To); err != nil {
+			return err
+		}
+	}
+	if r.Timeouts != nil {
+		for _, timeout := range []string{r.Timeouts.Connect, r.Timeouts.Server} {
+			if timeout != "" && !timeRegex.MatchString(timeout) {
+				return fmt.Errorf("invalid timeout %q, expected e.g. 30s", timeout)
+			}
+		}
+	}
+	if r.Redirect != nil {
+		if r.Redirect.Location == "" || strings.ContainsAny(r.Redirect.Location, haproxySyntax) {
+			return fmt.Errorf("invalid redirect location %q", r.Redirect.Location)
+		}
+		if r.Redirect.Code != 0 && !redirectCodes[r.Redirect.Code] {
+			return fmt.Errorf("invalid redirect code %d", r.Redirect.Code)
+		}
+	}
+	return nil
+}
+
+func validatePath(kind string, path string) error {
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, haproxySyntax) {
+		return fmt.Errorf("invalid %s %q, expected an absolute path without spaces, quotes, backslashes or #", kind, path)
+	}
+	return nil
+}
+
+// The stored form of the structured routing rules
+type storedRule struct {
+	Routing *Routing
+}
+
+/*
+	Returns the value a service is stored as: the raw ACL, as older Bamboo
+	versions store it, or the routing rules as a JSON object.
+*/
+func encodeRule(service Service) string {
+	if service.Routing == nil {
+		return service.Acl
+	}
+	value, _ := json.Marshal(storedRule{service.Routing})
+	return string(value)
+}
+
+/*
+	Returns the service stored with the value. Values which are not a JSON
+	object of routing rules are raw ACLs, which never start with "{".
+*/
+func decodeRule(appId string, value string, version string) Service {
+	service := Service{Id: appId, Version: version}
+	var stored storedRule
+	if strings.HasPrefix(strings.TrimSpace(value), "{") && json.Unmarshal([]byte(value), &stored) == nil && stored.Routing != nil {
+		service.Routing = stored.Routing
+	} else {
+		service.Acl = value
+	}
+	return service
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRouting(t *testing.T) {
+	Convey("#Validate", t, func() {
+		valid := func(routing Routing) Service {
+			return Service{Id: "/web", Routing: &routing}
+		}
+
+		Convey("should accept raw ACLs and routing rules", func() {
+			So(Service{Id: "/web", Acl: "hdr(host) -i web"}.Validate(), ShouldBeNil)
+			So(valid(Routing{
+				Hosts:        []string{"web.example.com", "*.web.example.com:8080"},
+				PathPrefixes: []string{"/web"},
+				Headers:      []HeaderMatch{{Name: "X-Canary"}, {Name: "X-Tenant", Value: "acme"}},
+				StripPrefix:  true,
+				Rewrite:      &PathRewrite{From: "/users", To: "/accounts"},
+				Timeouts:     &Timeouts{Connect: "500ms", Server: "2m"},
+				Redirect:     &Redirect{Location: "https://www.example.com", Code: 308},
+			}).Validate(), ShouldBeNil)
+		})
+
+		Convey("should reject services without rules, or with both kinds", func() {
+			So(Service{Id: "/web"}.Validate(), ShouldNotBeNil)
+			So(Service{Acl: "path_beg /web"}.Validate(), ShouldNotBeNil)
+			So(valid(Routing{}).Validate(), ShouldNotBeNil)
+			both := valid(Routing{Hosts: []string{"web.example.com"}})
+			both.Acl = "path_beg /web"
+			So(both.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("should reject values breaking the HAProxy configuration", func() {
+			So(Service{Id: "/web", Acl: "path_beg /web\nbind *:22"}.Validate(), ShouldNotBeNil)
+			So(valid(Routing{Hosts: []string{"web example.com"}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{PathPrefixes: []string{"web"}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{Headers: []HeaderMatch{{Name: "X Tenant"}}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{Hosts: []string{"web.example.com"}, StripPrefix: true}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{PathPrefixes: []string{"/web"}, Rewrite: &PathRewrite{From: "/a", To: "b"}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{PathPrefixes: []string{"/web"}, Timeouts: &Timeouts{Server: "forever"}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{PathPrefixes: []string{"/web"}, Redirect: &Redirect{Location: "https://x", Code: 200}}).Validate(), ShouldNotBeNil)
+		})
+
+		Convey("should reject comments and quotes in values", func() {
+			So(valid(Routing{PathPrefixes: []string{"/web#"}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{PathPrefixes: []string{"/web'"}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{Headers: []HeaderMatch{{Name: "X-Tenant", Value: "a#b"}}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{Headers: []HeaderMatch{{Name: "X#Tenant"}}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{PathPrefixes: []string{"/web"}, Rewrite: &PathRewrite{From: "/web", To: "/#"}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{PathPrefixes: []string{"/web"}, Redirect: &Redirect{Location: "https://x/#top"}}).Validate(), ShouldNotBeNil)
+			So(valid(Routing{PathPrefixes: []string{"/web"}, Redirect: &Redirect{Location: `https://x/"`}}).Validate(), ShouldNotBeNil)
+		})
+	})
+
+	Convey("#decodeRule", t, func() {
+		Convey("should read what encodeRule stores", func() {
+			service := Service{Id: "/web", Routing: &Routing{Hosts: []string{"web.example.com"}}, Version: "3"}
+			So(decodeRule("/web", encodeRule(service), "3"), ShouldResemble, service)
+		})
+
+		Convey("should read legacy values as raw ACLs", func() {
+			So(encodeRule(Service{Id: "/web", Acl: "path_beg /web"}), ShouldEqual, "path_beg /web")
+			So(decodeRule("/web", "path_beg /web", "1"), ShouldResemble, Service{Id: "/web", Acl: "path_beg /web", Version: "1"})
+			So(decodeRule("/web", "", "1").Acl, ShouldEqual, "")
+		})
+	})
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/samuel/go-zookeeper/zk"
+
+	conf "github.com/seomoz/roger-bamboo/configuration"
+)
+
+var (
+	ErrNotFound = errors.New("service not found")
+	ErrExists   = errors.New("service already exists")
+	ErrConflict = errors.New("service was changed meanwhile")
+)
+
+// Matches any version of a service
+const AnyVersion = ""
+
+/*
+	A Store keeps the routing rules of the services, keyed by the id of
+	their Marathon app. Services are read with their version, and updated
+	or deleted only as long as they still have the version given, unless
+	it is AnyVersion.
+*/
+type Store interface {
+	// Returns every service by id
+	All() (map[string]Service, error)
+	// Fails with ErrNotFound when there is no such service
+	Get(id string) (Service, error)
+	// Fails with ErrExists when the service already exists
+	Create(service Service) error
+	// Returns the new version of the service. Fails with ErrNotFound when
+	// there is no such service, ErrConflict when its version is not
+	// service.Version
+	Update(service Service) (string, error)
+	// Fails with ErrNotFound when there is no such service, ErrConflict
+	// when its version is not version
+	Delete(id string, version string) error
+
+	/*
+		Returns a channel receiving an Event whenever services may have
+		changed, until quit receives a value or is closed.
+	*/
+	Watch(quit <-chan bool) (<-chan Event, error)
+}
+
+// Notifies that services may have changed
+type Event struct {
+	// What happened, for the logs
+	EventType string
+}
+
+/*
+	Creates the store selected by Bamboo.Store.
+
+	Parameters:
+
+		conn: Zookeeper connection, only used by the Zookeeper store
+*/
+func NewStore(config *conf.Configuration, conn *zk.Conn) (Store, error) {
+	switch config.Bamboo.StoreType() {
+	case conf.StoreZookeeper:
+		return NewZookeeperStore(conn, config.Bamboo.Zookeeper)
+	case conf.StoreEtcd:
+		return NewEtcdStore(config.Bamboo.Etcd), nil
+	case conf.StoreConsul:
+		return NewConsulStore(config.Bamboo.Consul), nil
+	}
+	return nil, fmt.Errorf("unknown store %q", config.Bamboo.Store)
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	conf "github.com/seomoz/roger-bamboo/configuration"
+)
+
+// In-process key value storage behind the fake etcd and Consul servers
+type fakeKV struct {
+	lock    sync.Mutex
+	values  map[string]string
+	indexes map[string]uint64
+	index   uint64
+	changed chan bool
+}
+
+func newFakeKV() *fakeKV {
+	// Consul indexes start at 1
+	return &fakeKV{values: map[string]string{}, indexes: map[string]uint64{}, index: 1, changed: make(chan bool)}
+}
+
+// Must hold the lock
+func (kv *fakeKV) put(key string, value string) {
+	kv.index++
+	kv.values[key] = value
+	kv.indexes[key] = kv.index
+	close(kv.changed)
+	kv.changed = make(chan bool)
+}
+
+// Must hold the lock
+func (kv *fakeKV) delete(key string) bool {
+	if _, ok := kv.values[key]; !ok {
+		return false
+	}
+	kv.index++
+	delete(kv.values, key)
+	delete(kv.indexes, key)
+	close(kv.changed)
+	kv.changed = make(chan bool)
+	return true
+}
+
+// Must hold the lock
+func (kv *fakeKV) keys(prefix string) []string {
+	keys := []string{}
+	for key := range kv.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func b64(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func unb64(value string) string {
+	decoded, _ := base64.StdEncoding.DecodeString(value)
+	return string(decoded)
+}
+
+// Serves the parts of the etcd v3 JSON gateway used by EtcdStore
+func fakeEtcd(kv *fakeKV) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		field := func(m interface{}, name string) string {
+			value, _ := m.(map[string]interface{})[name].(string)
+			return unb64(value)
+		}
+
+		if r.URL.Path == "/v3/watch" {
+			kv.lock.Lock()
+			changed := kv.changed
+			kv.lock.Unlock()
+			w.Write([]byte(`{"result":{"created":true}}` + "\n"))
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case <-changed:
+					kv.lock.Lock()
+					changed = kv.changed
+					kv.lock.Unlock()
+					w.Write([]byte(`{"result":{"events":[{"kv":{}}]}}` + "\n"))
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			key, end := field(request, "key"), field(request, "range_end")
+			kvs := []map[string]string{}
+			for _, k := range kv.keys("") {
+				if k == key || (end != "" && k >= key && k < end) {
+					kvs = append(kvs, map[string]string{
+						"key": b64(k), "value": b64(kv.values[k]), "mod_revision": fmt.Sprint(kv.indexes[k]),
+					})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"kvs": kvs})
+		case "/v3/kv/txn":
+			compare := request["compare"].([]interface{})[0].(map[string]interface{})
+			key := field(compare, "key")
+			_, exists := kv.values[key]
+			succeeded := exists == (compare["result"] == "GREATER")
+			if compare["target"] == "MOD" {
+				succeeded = exists && fmt.Sprint(kv.indexes[key]) == compare["mod_revision"]
+			}
+			if succeeded {
+				operation := request["success"].([]interface{})[0].(map[string]interface{})
+				if put, ok := operation["request_put"]; ok {
+					kv.put(field(put, "key"), field(put, "value"))
+				} else {
+					kv.delete(field(operation["request_delete_range"], "key"))
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"header": map[string]string{"revision": fmt.Sprint(kv.index)}, "succeeded": succeeded,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// Serves the parts of the Consul KV API used by ConsulStore
+func fakeConsul(kv *fakeKV) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		query := r.URL.Query()
+
+		kv.lock.Lock()
+		if index, _ := strconv.ParseUint(query.Get("index"), 10, 64); index > 0 && index >= kv.index {
+			changed := kv.changed
+			kv.lock.Unlock()
+			select {
+			case <-changed:
+			case <-time.After(time.Second):
+			}
+			kv.lock.Lock()
+		}
+		defer kv.lock.Unlock()
+		w.Header().Set("X-Consul-Index", fmt.Sprint(kv.index))
+
+		if r.URL.Path == "/v1/txn" {
+			var operations []consulTxnOperation
+			json.NewDecoder(r.Body).Decode(&operations)
+			op := operations[0].KV
+			if index, exists := kv.indexes[op.Key]; op.Verb != "cas" || (op.Index == 0 && exists) || (op.Index > 0 && index != op.Index) {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(`{"Results": null, "Errors": [{"OpIndex": 0, "What": "index is stale"}]}`))
+				return
+			}
+			kv.put(op.Key, unb64(op.Value))
+			json.NewEncoder(w).Encode(map[string]interface{}{"Results": []map[string]consulKeyValue{
+				{"KV": {Key: op.Key, ModifyIndex: kv.indexes[op.Key]}},
+			}})
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			keys := []string{}
+			if query.Get("recurse") != "" {
+				keys = kv.keys(key)
+			} else if _, ok := kv.values[key]; ok {
+				keys = []string{key}
+			}
+			if len(keys) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			kvs := []consulKeyValue{}
+			for _, k := range keys {
+				kvs = append(kvs, consulKeyValue{Key: k, Value: b64(kv.values[k]), ModifyIndex: kv.indexes[k]})
+			}
+			json.NewEncoder(w).Encode(kvs)
+		case "DELETE":
+			if cas, _ := strconv.ParseUint(query.Get("cas"), 10, 64); cas > 0 && kv.indexes[key] != cas {
+				w.Write([]byte("false"))
+				return
+			}
+			kv.delete(key)
+			w.Write([]byte("true"))
+		}
+	}))
+}
+
+func testStore(store Store) {
+	Convey("should create, list, get, update and delete services", func() {
+		So(store.Create(Service{Id: "/web", Acl: "hdr(host) -i web"}), ShouldBeNil)
+		So(store.Create(Service{Id: "/group/api", Acl: "path_beg /api"}), ShouldBeNil)
+
+		services, err := store.All()
+		So(err, ShouldBeNil)
+		So(services, ShouldHaveLength, 2)
+		So(services["/web"].Acl, ShouldEqual, "hdr(host) -i web")
+		So(services["/group/api"].Acl, ShouldEqual, "path_beg /api")
+
+		_, err = store.Update(Service{Id: "/web", Acl: "hdr(host) -i www"})
+		So(err, ShouldBeNil)
+		service, err := store.Get("/web")
+		So(err, ShouldBeNil)
+		So(service.Acl, ShouldEqual, "hdr(host) -i www")
+
+		So(store.Delete("/web", AnyVersion), ShouldBeNil)
+		_, err = store.Get("/web")
+		So(err, ShouldEqual, ErrNotFound)
+	})
+
+	Convey("should report missing and existing services", func() {
+		So(store.Create(Service{Id: "/web"}), ShouldBeNil)
+		So(store.Create(Service{Id: "/web"}), ShouldEqual, ErrExists)
+		_, err := store.Update(Service{Id: "/missing"})
+		So(err, ShouldEqual, ErrNotFound)
+		So(store.Delete("/missing", AnyVersion), ShouldEqual, ErrNotFound)
+		_, err = store.Update(Service{Id: "/missing", Version: "1"})
+		So(err, ShouldEqual, ErrNotFound)
+		So(store.Delete("/missing", "1"), ShouldEqual, ErrNotFound)
+	})
+
+	Convey("should only change services still having the given version", func() {
+		So(store.Create(Service{Id: "/web", Acl: "hdr(host) -i web"}), ShouldBeNil)
+		read, err := store.Get("/web")
+		So(err, ShouldBeNil)
+		So(read.Version, ShouldNotEqual, AnyVersion)
+
+		version, err := store.Update(Service{Id: "/web", Acl: "hdr(host) -i www", Version: read.Version})
+		So(err, ShouldBeNil)
+		updated, err := store.Get("/web")
+		So(err, ShouldBeNil)
+		So(updated.Version, ShouldNotEqual, read.Version)
+		So(version, ShouldEqual, updated.Version)
+
+		// Changed meanwhile
+		_, err = store.Update(Service{Id: "/web", Acl: "hdr(host) -i old", Version: read.Version})
+		So(err, ShouldEqual, ErrConflict)
+		So(store.Delete("/web", read.Version), ShouldEqual, ErrConflict)
+		_, err = store.Update(Service{Id: "/web", Version: "not a version"})
+		So(err, ShouldEqual, ErrConflict)
+
+		So(store.Delete("/web", updated.Version), ShouldBeNil)
+		_, err = store.Get("/web")
+		So(err, ShouldEqual, ErrNotFound)
+	})
+
+	Convey("should store routing rules next to raw ACLs", func() {
+		routing := &Routing{Hosts: []string{"www.example.com"}, PathPrefixes: []string{"/web"}, StripPrefix: true}
+		So(store.Create(Service{Id: "/web", Routing: routing}), ShouldBeNil)
+		So(store.Create(Service{Id: "/legacy", Acl: "path_beg /legacy"}), ShouldBeNil)
+
+		services, err := store.All()
+		So(err, ShouldBeNil)
+		So(services["/web"].Routing, ShouldResemble, routing)
+		So(services["/web"].Acl, ShouldEqual, "")
+		So(services["/legacy"].Routing, ShouldBeNil)
+		So(services["/legacy"].Acl, ShouldEqual, "path_beg /legacy")
+	})
+
+	Convey("should notify changes", func() {
+		quit := make(chan bool)
+		defer close(quit)
+		events, err := store.Watch(quit)
+		So(err, ShouldBeNil)
+
+		// Wait for the watch to be set up
+		time.Sleep(50 * time.Millisecond)
+		So(store.Create(Service{Id: "/web"}), ShouldBeNil)
+		select {
+		case <-events:
+		case <-time.After(2 * time.Second):
+			So("no event", ShouldBeEmpty)
+		}
+	})
+}
+
+func TestEtcdStore(t *testing.T) {
+	Convey("#EtcdStore", t, func() {
+		server := fakeEtcd(newFakeKV())
+		defer server.Close()
+		testStore(NewEtcdStore(conf.Etcd{Endpoint: "http://127.0.0.1:1," + server.URL}))
+	})
+}
+
+func TestConsulStore(t *testing.T) {
+	Convey("#ConsulStore", t, func() {
+		server := fakeConsul(newFakeKV())
+		defer server.Close()
+		testStore(NewConsulStore(conf.Consul{Endpoint: server.URL}))
+	})
+}
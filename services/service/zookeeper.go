@@ -0,0 +1,173 @@
+package service
+
+import (
+	"strconv"
+
+	"github.com/samuel/go-zookeeper/zk"
+
+	conf "github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/qzk"
+)
+
+/*
+	Stores every service as a child of Zookeeper.Path, named after the
+	escaped app id and holding the rule, see encodeRule.
+*/
+type ZookeeperStore struct {
+	conn   *zk.Conn
+	zkConf conf.Zookeeper
+	// ACL of the created nodes
+	acl []zk.ACL
+}
+
+func NewZookeeperStore(conn *zk.Conn, zkConf conf.Zookeeper) (*ZookeeperStore, error) {
+	acl, err := qzk.ACL(zkConf)
+	if err != nil {
+		return nil, err
+	}
+	return &ZookeeperStore{conn: conn, zkConf: zkConf, acl: acl}, nil
+}
+
+func (s *ZookeeperStore) All() (map[string]Service, error) {
+	err := ensurePathExists(s.conn, s.zkConf.Path, s.acl)
+	if err != nil {
+		return nil, err
+	}
+
+	services := map[string]Service{}
+	keys, _, err2 := s.conn.Children(s.zkConf.Path)
+
+	if err2 != nil {
+		return nil, err2
+	}
+
+	for _, childPath := range keys {
+		bite, stat, e := s.conn.Get(s.zkConf.Path + "/" + childPath)
+		if e == zk.ErrNoNode {
+			// Deleted meanwhile
+			continue
+		} else if e != nil {
+			return nil, e
+		}
+		appId, _ := unescapeSlashes(childPath)
+		services[appId] = decodeRule(appId, string(bite), zookeeperVersion(stat))
+	}
+	return services, nil
+}
+
+func (s *ZookeeperStore) Get(appId string) (Service, error) {
+	bite, stat, err := s.conn.Get(concatPath(s.zkConf.Path, appId))
+	if err != nil {
+		return Service{}, zookeeperError(err)
+	}
+	return decodeRule(appId, string(bite), zookeeperVersion(stat)), nil
+}
+
+/*
+	Read ZK ACL:
+	http://zookeeper.apache.org/doc/trunk/zookeeperProgrammers.html#sc_ACLPermissions
+*/
+func (s *ZookeeperStore) Create(service Service) error {
+	if err := ensurePathExists(s.conn, s.zkConf.Path, s.acl); err != nil {
+		return err
+	}
+	path := concatPath(s.zkConf.Path, service.Id)
+	_, err := s.conn.Create(path, []byte(encodeRule(service)), 0, s.acl)
+	return zookeeperError(err)
+}
+
+// The version is the one of the node, Zookeeper checking it on update
+func (s *ZookeeperStore) Update(service Service) (string, error) {
+	version, err := nodeVersion(service.Version)
+	if err != nil {
+		return "", err
+	}
+	path := concatPath(s.zkConf.Path, service.Id)
+	stat, err := s.conn.Set(path, []byte(encodeRule(service)), version)
+	if err != nil {
+		return "", zookeeperError(err)
+	}
+	return zookeeperVersion(stat), nil
+}
+
+func (s *ZookeeperStore) Delete(appId string, version string) error {
+	nodeVersion, err := nodeVersion(version)
+	if err != nil {
+		return err
+	}
+	path := concatPath(s.zkConf.Path, appId)
+	return zookeeperError(s.conn.Delete(path, nodeVersion))
+}
+
+/*
+	Watches the services node, its children and their data, see
+	qzk.Watcher. Events are debounced and delayed by
+	Zookeeper.ReportingDelay.
+*/
+func (s *ZookeeperStore) Watch(quit <-chan bool) (<-chan Event, error) {
+	zkEvents, zkQuit := qzk.ListenToConn(s.conn, s.zkConf.Path, s.acl, true, s.zkConf.Delay())
+	events := make(chan Event)
+	go func() {
+		defer close(zkQuit)
+		for {
+			select {
+			case <-quit:
+				return
+			case zkEvent := <-zkEvents:
+				select {
+				case events <- Event{EventType: zkEvent.Type.String()}:
+				case <-quit:
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+func zookeeperError(err error) error {
+	switch err {
+	case zk.ErrNoNode:
+		return ErrNotFound
+	case zk.ErrNodeExists:
+		return ErrExists
+	case zk.ErrBadVersion:
+		return ErrConflict
+	}
+	return err
+}
+
+func zookeeperVersion(stat *zk.Stat) string {
+	return strconv.Itoa(int(stat.Version))
+}
+
+// Returns the node version of a service version, -1 matching any
+func nodeVersion(version string) (int32, error) {
+	if version == AnyVersion {
+		return -1, nil
+	}
+	parsed, err := strconv.ParseInt(version, 10, 32)
+	if err != nil || parsed < 0 {
+		// No node ever has this version
+		return 0, ErrConflict
+	}
+	return int32(parsed), nil
+}
+
+func concatPath(parentPath string, appId string) string {
+	return parentPath + "/" + escapeSlashes(appId)
+}
+
+func ensurePathExists(conn *zk.Conn, path string, acl []zk.ACL) error {
+	pathExists, _, _ := conn.Exists(path)
+	if pathExists {
+		return nil
+	}
+
+	_, err := conn.Create(path, []byte{}, 0, acl)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
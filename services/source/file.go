@@ -0,0 +1,240 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+// Extensions of the files read from a directory
+var fileExtensions = map[string]bool{".yml": true, ".yaml": true, ".json": true}
+
+/*
+	The apps of a file. Apps are described with the fields of the Marathon
+	API, see marathon.MarathonApp, along with a few shortcuts:
+
+		apps:
+		  - id: /legacy/billing
+		    tasks:
+		      - host: 10.0.4.12
+		        ports: [8080, 8081]
+		    env:
+		      HTTP_PORT: PORT0
+		    tcpPorts:
+		      "3300": PORT1
+		    healthCheck:
+		      path: /health
+		      intervalSeconds: 10
+
+	YAML documents are converted to JSON before being read, so that both
+	formats share the same field names.
+*/
+type fileApps struct {
+	Apps []fileApp `json:"apps"`
+}
+
+type fileApp struct {
+	marathon.MarathonApp
+	// External TCP ports, the bamboo.tcp.ports label of a Marathon app
+	TcpPorts map[string]string `json:"tcpPorts"`
+	// Added to HealthChecks, for the common case of a single check
+	HealthCheck *marathon.HealthCheck `json:"healthCheck"`
+}
+
+/*
+	File reads the apps of static YAML or JSON files, for services which do
+	not run on Marathon. Path is either a file or a directory, whose .yml,
+	.yaml and .json files are all read. Changes of the files are notified
+	through inotify.
+*/
+type File struct {
+	name string
+	path string
+
+	once    sync.Once
+	changes chan Change
+}
+
+func NewFile(name string, path string) (*File, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing Path of source %s", name)
+	}
+	return &File{name: name, path: path}, nil
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+/*
+	Reads every file. Fails when a file cannot be read, so that a file
+	being edited does not drop its apps from HAProxy.
+*/
+func (f *File) Apps() (marathon.AppList, error) {
+	files, err := f.files()
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := []marathon.MarathonApp{}
+	definedIn := map[string]string{}
+	for _, file := range files {
+		apps, err := readFileApps(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range apps {
+			if other, exists := definedIn[app.Id]; exists {
+				log.Printf("Ignoring app %s of %s, already defined in %s\n", app.Id, file, other)
+				continue
+			}
+			definedIn[app.Id] = file
+			definitions = append(definitions, app)
+		}
+	}
+
+	// HAProxy checks the health of the tasks
+	return marathon.NewApps(definitions, marathon.TaskFilterAll), nil
+}
+
+// Returns the files to read, in order
+func (f *File) files() ([]string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{f.path}, nil
+	}
+
+	entries, err := ioutil.ReadDir(f.path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && f.watches(entry.Name()) {
+			files = append(files, filepath.Join(f.path, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Returns the directory to watch, holding the files
+func (f *File) dir() string {
+	if info, err := os.Stat(f.path); err == nil && info.IsDir() {
+		return f.path
+	}
+	return filepath.Dir(f.path)
+}
+
+// Whether a file of the watched directory is one of the files to read
+func (f *File) watches(name string) bool {
+	if info, err := os.Stat(f.path); err == nil && info.IsDir() {
+		return !strings.HasPrefix(name, ".") && fileExtensions[strings.ToLower(filepath.Ext(name))]
+	}
+	return name == filepath.Base(f.path)
+}
+
+/*
+	Notifies changes of the files. The directory holding them is watched
+	rather than the files, as editors usually replace a file when saving it.
+*/
+func (f *File) Changes() <-chan Change {
+	f.once.Do(func() {
+		changes := make(chan Change)
+		err := watchDir(f.dir(), func(name string) {
+			if f.watches(name) {
+				changes <- Change{Source: f.name, Reason: "changed " + name}
+			}
+		})
+		if err != nil {
+			log.Printf("Unable to watch %s, relying on periodic updates: %s\n", f.dir(), err)
+			return
+		}
+		log.Printf("Watching %s for changes\n", f.dir())
+		f.changes = changes
+	})
+	if f.changes == nil {
+		return nil
+	}
+	return f.changes
+}
+
+func readFileApps(path string) ([]marathon.MarathonApp, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yml" || ext == ".yaml" {
+		if content, err = yamlToJSON(content); err != nil {
+			return nil, fmt.Errorf("invalid YAML in %s: %s", path, err)
+		}
+	}
+
+	var document fileApps
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("invalid apps in %s: %s", path, err)
+	}
+
+	apps := []marathon.MarathonApp{}
+	for i, app := range document.Apps {
+		if app.Id == "" {
+			return nil, fmt.Errorf("app %d of %s has no id", i+1, path)
+		}
+		if !strings.HasPrefix(app.Id, "/") {
+			app.Id = "/" + app.Id
+		}
+		if app.HealthCheck != nil {
+			app.HealthChecks = append([]marathon.HealthCheck{*app.HealthCheck}, app.HealthChecks...)
+		}
+		if len(app.TcpPorts) > 0 {
+			tcpPorts, _ := json.Marshal(app.TcpPorts)
+			labels := map[string]string{"bamboo.tcp.ports": string(tcpPorts)}
+			for key, value := range app.Labels {
+				labels[key] = value
+			}
+			app.Labels = labels
+		}
+		apps = append(apps, app.MarathonApp)
+	}
+	return apps, nil
+}
+
+// Converts a YAML document to JSON, YAML mappings having string keys only
+func yamlToJSON(content []byte) ([]byte, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(stringKeys(document))
+}
+
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range value {
+			result[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = stringKeys(item)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,93 @@
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const billingYAML = `
+apps:
+  - id: legacy/billing
+    tasks:
+      - host: 10.0.4.12
+        ports: [8080, 8081]
+    env:
+      HTTP_PORT: PORT0
+    tcpPorts:
+      "3300": PORT1
+    healthCheck:
+      path: /health
+      intervalSeconds: 10
+`
+
+const searchJSON = `{
+  "apps": [
+    {"id": "/legacy/search", "tasks": [{"host": "10.0.4.13", "ports": [9200]}]},
+    {"id": "/legacy/billing", "tasks": [{"host": "10.0.4.14", "ports": [1]}]}
+  ]
+}`
+
+func TestFile(t *testing.T) {
+	Convey("#File", t, func() {
+		dir, _ := ioutil.TempDir("", "bamboo-file-source")
+		defer os.RemoveAll(dir)
+		ioutil.WriteFile(filepath.Join(dir, "billing.yml"), []byte(billingYAML), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "search.json"), []byte(searchJSON), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not apps"), 0644)
+
+		Convey("should read the apps of every file of a directory", func() {
+			file, _ := NewFile("file", dir)
+			apps, err := file.Apps()
+			So(err, ShouldBeNil)
+			So(len(apps), ShouldEqual, 2)
+
+			billing := apps[0]
+			So(billing.Id, ShouldEqual, "/legacy/billing")
+			So(billing.Tasks[0].Host, ShouldEqual, "10.0.4.12")
+			So(billing.HttpPort, ShouldEqual, "PORT0")
+			So(billing.TcpPorts, ShouldResemble, map[string]string{"3300": "PORT1"})
+			So(billing.HealthCheckPath, ShouldEqual, "/health")
+			So(billing.HealthChecks[0].IntervalSeconds, ShouldEqual, 10)
+			So(apps[1].Id, ShouldEqual, "/legacy/search")
+		})
+
+		Convey("should read a single file", func() {
+			file, _ := NewFile("file", filepath.Join(dir, "search.json"))
+			apps, err := file.Apps()
+			So(err, ShouldBeNil)
+			So(len(apps), ShouldEqual, 2)
+			So(apps[0].Tasks[0].Host, ShouldEqual, "10.0.4.14")
+		})
+
+		Convey("should fail on invalid files", func() {
+			ioutil.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("apps: [{id: "), 0644)
+			file, _ := NewFile("file", dir)
+			_, err := file.Apps()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should notify changes of the files", func() {
+			if runtime.GOOS != "linux" {
+				return
+			}
+			file, _ := NewFile("static", dir)
+			changes := file.Changes()
+			So(changes, ShouldNotBeNil)
+
+			ioutil.WriteFile(filepath.Join(dir, "ignored.txt"), []byte(""), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "search.json"), []byte(searchJSON), 0644)
+			select {
+			case change := <-changes:
+				So(change, ShouldResemble, Change{Source: "static", Reason: "changed search.json"})
+			case <-time.After(5 * time.Second):
+				t.Error("No change notified")
+			}
+		})
+	})
+}
@@ -0,0 +1,142 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/services/marathon"
+	"github.com/seomoz/roger-bamboo/services/mesos"
+)
+
+/*
+	Marathon fetches the apps of a Marathon cluster, setting their Cluster
+	to the name of the source. With UseEventStream, it
+	notifies changes as events arrive on the /v2/events stream. Otherwise
+	Marathon calls the event subscription callback of the API instead.
+
+	While every Marathon endpoint fails, the tasks are read from the Mesos
+	masters of the cluster instead, when configured, and put on the app
+	definitions last read from Marathon. Updates are skipped when Marathon
+	has never been read. The gauge source.<name>.fallback is 1 meanwhile.
+*/
+type Marathon struct {
+	name   string
+	config configuration.Marathon
+	statsd *configuration.StatsD
+
+	once    sync.Once
+	changes chan Change
+
+	fallbackLock sync.Mutex
+	fallback     bool
+	// The app definitions last read from Marathon
+	definitions []marathon.MarathonApp
+}
+
+func NewMarathon(name string, config configuration.Marathon, statsd *configuration.StatsD) *Marathon {
+	return &Marathon{name: name, config: config, statsd: statsd}
+}
+
+func (m *Marathon) Name() string {
+	return m.name
+}
+
+func (m *Marathon) Apps() (marathon.AppList, error) {
+	var apps marathon.AppList
+	definitions, err := marathon.FetchDefinitions(m.config)
+	if err == nil {
+		m.setDefinitions(definitions)
+		apps = marathon.NewApps(definitions, m.config.TaskFilter)
+		m.setFallback(false)
+	} else if m.config.Mesos.Enabled() {
+		log.Printf("Marathon %s is unavailable, reading its tasks from Mesos: %s\n", m.name, err)
+		apps, err = m.fallbackApps()
+		if err != nil {
+			log.Printf("Unable to read the tasks of Marathon %s from Mesos: %s\n", m.name, err)
+		}
+		m.setFallback(true)
+	}
+
+	for i := range apps {
+		apps[i].Cluster = m.name
+	}
+	return apps, err
+}
+
+func (m *Marathon) fallbackApps() (marathon.AppList, error) {
+	m.fallbackLock.Lock()
+	definitions := m.definitions
+	m.fallbackLock.Unlock()
+
+	// Apps made of their tasks only would lose their settings
+	if definitions == nil {
+		return nil, errors.New("no app definitions read from Marathon yet")
+	}
+	return mesos.FetchApps(m.config, definitions)
+}
+
+func (m *Marathon) setDefinitions(definitions []marathon.MarathonApp) {
+	m.fallbackLock.Lock()
+	defer m.fallbackLock.Unlock()
+	m.definitions = definitions
+}
+
+// Whether the apps are currently read from Mesos
+func (m *Marathon) Fallback() bool {
+	m.fallbackLock.Lock()
+	defer m.fallbackLock.Unlock()
+	return m.fallback
+}
+
+func (m *Marathon) setFallback(fallback bool) {
+	m.fallbackLock.Lock()
+	changed := fallback != m.fallback
+	m.fallback = fallback
+	m.fallbackLock.Unlock()
+
+	if changed && fallback {
+		log.Printf("Marathon %s: entering fallback mode\n", m.name)
+	} else if changed {
+		log.Printf("Marathon %s: leaving fallback mode\n", m.name)
+	}
+	if fallback {
+		m.statsd.Increment(1.0, "source."+m.name+".fallback_fetches", 1)
+		m.statsd.Gauge(1.0, "source."+m.name+".fallback", "1")
+	} else {
+		m.statsd.Gauge(1.0, "source."+m.name+".fallback", "0")
+	}
+}
+
+func (m *Marathon) Changes() <-chan Change {
+	if !m.config.UseEventStream {
+		return nil
+	}
+	m.once.Do(func() {
+		m.changes = make(chan Change)
+		go m.listenToEventStream()
+	})
+	return m.changes
+}
+
+func (m *Marathon) listenToEventStream() {
+	log.Println("Listening to Marathon event stream")
+	events := make(chan marathon.StreamEvent)
+	go marathon.ListenToEventStream(m.config, events, make(chan bool))
+
+	for streamEvent := range events {
+		var event struct {
+			EventType string `json:"eventType"`
+		}
+		if err := json.Unmarshal(streamEvent.Data, &event); err != nil {
+			log.Printf("Unable to decode JSON Marathon Event: %s \n", string(streamEvent.Data))
+			continue
+		}
+		if event.EventType == "" {
+			event.EventType = streamEvent.Event
+		}
+		m.changes <- Change{Source: m.name, Reason: event.EventType}
+	}
+}
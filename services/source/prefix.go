@@ -0,0 +1,40 @@
+package source
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+/*
+	Prefixes the ids of the apps of a source, so that apps with the same id
+	in several sources, e.g. several Marathon clusters, get distinct backend
+	names.
+*/
+type prefixed struct {
+	Source
+	prefix      string
+	escapedOnly bool
+}
+
+func newPrefixed(source Source, prefix string, escapedOnly bool) *prefixed {
+	prefix = "/" + strings.Trim(prefix, "/")
+	return &prefixed{Source: source, prefix: prefix, escapedOnly: escapedOnly}
+}
+
+func (p *prefixed) Apps() (marathon.AppList, error) {
+	apps, err := p.Source.Apps()
+	if err != nil {
+		return nil, err
+	}
+	escapedPrefix := strings.Replace(p.prefix, "/", "::", -1)
+	for i := range apps {
+		apps[i].EscapedId = escapedPrefix + apps[i].EscapedId
+		if !p.escapedOnly {
+			apps[i].Id = p.prefix + apps[i].Id
+		}
+	}
+	sort.Sort(apps)
+	return apps, nil
+}
@@ -0,0 +1,171 @@
+package source
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+// Source types of the configuration
+const (
+	TypeMarathon = "marathon"
+	TypeFile     = "file"
+)
+
+/*
+	A Source discovers the apps to load balance, e.g. from Marathon. Every
+	source describes its apps with the same App and Task model, so that the
+	templates do not depend on where the apps come from.
+*/
+type Source interface {
+	// Identifies the source in logs, StatsD and reload triggers
+	Name() string
+
+	// Returns the current apps of the source, sorted by id
+	Apps() (marathon.AppList, error)
+
+	// Returns a channel receiving a Change whenever the apps of the source
+	// may have changed, nil when the source does not notify its changes
+	Changes() <-chan Change
+}
+
+// Notifies that the apps of a source may have changed
+type Change struct {
+	Source string
+	// What happened, for the logs, e.g. the type of a Marathon event
+	Reason string
+}
+
+/*
+	Creates the sources listed in config.Sources, combined into a single one
+	when there are several.
+*/
+func New(config *configuration.Configuration) (Source, error) {
+	sources := []Source{}
+	names := map[string]bool{}
+	for _, sourceConf := range config.SourceList() {
+		if names[sourceConf.Name] {
+			return nil, fmt.Errorf("duplicate source name %q", sourceConf.Name)
+		}
+		names[sourceConf.Name] = true
+
+		source, err := newSource(config, sourceConf)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	if len(sources) == 1 {
+		return sources[0], nil
+	}
+	return &Combined{Sources: sources}, nil
+}
+
+func newSource(config *configuration.Configuration, sourceConf configuration.Source) (Source, error) {
+	var source Source
+	var err error
+	switch sourceConf.Type {
+	case TypeMarathon:
+		source = NewMarathon(sourceConf.Name, config.MarathonOf(sourceConf), &config.StatsD)
+	case TypeFile:
+		source, err = NewFile(sourceConf.Name, sourceConf.Path)
+	default:
+		err = fmt.Errorf("unknown type %q of source %s", sourceConf.Type, sourceConf.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sourceConf.IdPrefix != "" {
+		source = newPrefixed(source, sourceConf.IdPrefix, sourceConf.PrefixEscapedIdOnly)
+	}
+	return source, nil
+}
+
+/*
+	Combined merges the apps of several sources. When sources have an app
+	with the same EscapedId, naming its backends, the source listed first
+	keeps it.
+*/
+type Combined struct {
+	Sources []Source
+
+	once    sync.Once
+	changes chan Change
+}
+
+func (c *Combined) Name() string {
+	names := []string{}
+	for _, source := range c.Sources {
+		names = append(names, source.Name())
+	}
+	return strings.Join(names, "+")
+}
+
+/*
+	Fetches the apps of every source concurrently. Fails when any source
+	fails, as rendering without the apps of a source would drop them from
+	HAProxy.
+*/
+func (c *Combined) Apps() (marathon.AppList, error) {
+	results := make([]marathon.AppList, len(c.Sources))
+	errors := make([]error, len(c.Sources))
+
+	var wait sync.WaitGroup
+	for i, source := range c.Sources {
+		wait.Add(1)
+		go func(i int, source Source) {
+			defer wait.Done()
+			results[i], errors[i] = source.Apps()
+		}(i, source)
+	}
+	wait.Wait()
+
+	for i, err := range errors {
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %s", c.Sources[i].Name(), err)
+		}
+	}
+	return merge(c.Sources, results), nil
+}
+
+func merge(sources []Source, results []marathon.AppList) marathon.AppList {
+	apps := marathon.AppList{}
+	owners := map[string]string{}
+	for i, result := range results {
+		for _, app := range result {
+			if owner, exists := owners[app.EscapedId]; exists {
+				log.Printf("Ignoring app %s of source %s, already provided by source %s\n",
+					app.Id, sources[i].Name(), owner)
+				continue
+			}
+			owners[app.EscapedId] = sources[i].Name()
+			apps = append(apps, app)
+		}
+	}
+	sort.Sort(apps)
+	return apps
+}
+
+// Forwards the changes of every source notifying them
+func (c *Combined) Changes() <-chan Change {
+	c.once.Do(func() {
+		c.changes = make(chan Change)
+		for _, source := range c.Sources {
+			if changes := source.Changes(); changes != nil {
+				go func(changes <-chan Change) {
+					for change := range changes {
+						c.changes <- change
+					}
+				}(changes)
+			}
+		}
+	})
+	return c.changes
+}
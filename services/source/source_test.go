@@ -0,0 +1,168 @@
+package source
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+type fakeSource struct {
+	name    string
+	apps    marathon.AppList
+	err     error
+	changes chan Change
+}
+
+func (f *fakeSource) Name() string                    { return f.name }
+func (f *fakeSource) Apps() (marathon.AppList, error) { return f.apps, f.err }
+func (f *fakeSource) Changes() <-chan Change {
+	if f.changes == nil {
+		return nil
+	}
+	return f.changes
+}
+
+func fakeApp(id string, from string) marathon.App {
+	return marathon.App{Id: id, EscapedId: strings.Replace(id, "/", "::", -1),
+		Env: map[string]string{"FROM": from}}
+}
+
+func TestCombined(t *testing.T) {
+	Convey("#Combined", t, func() {
+		first := &fakeSource{name: "first", apps: marathon.AppList{
+			fakeApp("/web", "first"), fakeApp("/api", "first"),
+		}}
+		second := &fakeSource{name: "second", apps: marathon.AppList{
+			fakeApp("/web", "second"), fakeApp("/cache", "second"),
+		}}
+		combined := &Combined{Sources: []Source{first, second}}
+
+		Convey("should merge the apps sorted by id, the first source keeping duplicate ids", func() {
+			apps, err := combined.Apps()
+			So(err, ShouldBeNil)
+			So(len(apps), ShouldEqual, 3)
+			So(apps[0].Id, ShouldEqual, "/api")
+			So(apps[1].Id, ShouldEqual, "/cache")
+			So(apps[2].Id, ShouldEqual, "/web")
+			So(apps[2].Env["FROM"], ShouldEqual, "first")
+		})
+
+		Convey("should fail when any source fails", func() {
+			second.err = errors.New("unreachable")
+			_, err := combined.Apps()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "second")
+		})
+
+		Convey("should forward the changes of the sources notifying them", func() {
+			second.changes = make(chan Change)
+			go func() { second.changes <- Change{Source: "second", Reason: "edit"} }()
+			So(<-combined.Changes(), ShouldResemble, Change{Source: "second", Reason: "edit"})
+		})
+
+		Convey("should be named after its sources", func() {
+			So(combined.Name(), ShouldEqual, "first+second")
+		})
+
+		Convey("should keep apps with the same id in prefixed sources", func() {
+			combined.Sources[1] = newPrefixed(second, "west/", false)
+			apps, err := combined.Apps()
+			So(err, ShouldBeNil)
+			So(len(apps), ShouldEqual, 4)
+			So(apps[3].Id, ShouldEqual, "/west/web")
+			So(apps[3].EscapedId, ShouldEqual, "::west::web")
+			So(apps[3].Env["FROM"], ShouldEqual, "second")
+		})
+
+		Convey("should keep apps prefixing their EscapedId only", func() {
+			combined.Sources[1] = newPrefixed(second, "/west", true)
+			apps, _ := combined.Apps()
+			So(len(apps), ShouldEqual, 4)
+			So(apps[3].Id, ShouldEqual, "/web")
+			So(apps[3].EscapedId, ShouldEqual, "::west::web")
+		})
+	})
+}
+
+func TestNew(t *testing.T) {
+	Convey("#New", t, func() {
+		Convey("should default to Marathon", func() {
+			src, err := New(&configuration.Configuration{})
+			So(err, ShouldBeNil)
+			So(src.Name(), ShouldEqual, "marathon")
+			So(src.Changes(), ShouldBeNil)
+		})
+
+		Convey("should combine several sources", func() {
+			src, err := New(&configuration.Configuration{Sources: []configuration.Source{
+				{Type: "Marathon"}, {Type: "marathon", Name: "backup"},
+			}})
+			So(err, ShouldBeNil)
+			So(src.Name(), ShouldEqual, "marathon+backup")
+		})
+
+		Convey("should reject unknown types and duplicate names", func() {
+			_, err := New(&configuration.Configuration{Sources: []configuration.Source{{Type: "dns"}}})
+			So(err, ShouldNotBeNil)
+			_, err = New(&configuration.Configuration{Sources: []configuration.Source{
+				{Type: "marathon"}, {Type: "marathon"},
+			}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMarathonFallback(t *testing.T) {
+	Convey("#Marathon", t, func() {
+		available := false
+		marathonServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !available {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"apps": [{"id": "/web", "env": {"TCP_PORTS": "{\"3300\": \"PORT0\"}"}, "tasks": []},
+				{"id": "/idle", "tasks": []}]}`))
+		}))
+		defer marathonServer.Close()
+		mesosServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"slaves": [{"id": "S1", "hostname": "agent1"}], "frameworks": [{"name": "marathon",
+				"tasks": [{"id": "web.1111", "slave_id": "S1", "state": "TASK_RUNNING", "resources": {"ports": "[31000-31000]"}}]}]}`))
+		}))
+		defer mesosServer.Close()
+
+		maraconf := configuration.Marathon{Endpoint: marathonServer.URL,
+			Mesos: configuration.Mesos{Endpoint: mesosServer.URL}}
+		source := NewMarathon("east", maraconf, &configuration.StatsD{})
+
+		Convey("should put the tasks read from Mesos on the apps last read from Marathon", func() {
+			available = true
+			_, err := source.Apps()
+			So(err, ShouldBeNil)
+			So(source.Fallback(), ShouldBeFalse)
+
+			available = false
+			apps, err := source.Apps()
+			So(err, ShouldBeNil)
+			So(len(apps), ShouldEqual, 2)
+			So(apps[0].Id, ShouldEqual, "/idle")
+			So(apps[1].Id, ShouldEqual, "/web")
+			So(apps[1].Cluster, ShouldEqual, "east")
+			So(apps[1].TcpPorts, ShouldResemble, map[string]string{"3300": "PORT0"})
+			So(apps[1].Tasks[0].Host, ShouldEqual, "agent1")
+			So(source.Fallback(), ShouldBeTrue)
+		})
+
+		Convey("should fail until Marathon has been read", func() {
+			_, err := source.Apps()
+			So(err, ShouldNotBeNil)
+			So(source.Fallback(), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,62 @@
+//go:build linux
+// +build linux
+
+package source
+
+import (
+	"log"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Events of the files of a directory whose content may have changed
+const watchedEvents = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+/*
+	Watches the files of a directory with inotify, calling changed with the
+	name of every file written, created, deleted or renamed. Returns once
+	the watch is set up, calling changed from another goroutine.
+*/
+func watchDir(dir string, changed func(name string)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, watchedEvents); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buffer)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				log.Printf("Stopped watching %s: %v\n", dir, err)
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				name := strings.TrimRight(string(buffer[nameStart:nameStart+int(event.Len)]), "\x00")
+				offset = nameStart + int(event.Len)
+
+				if event.Mask&syscall.IN_IGNORED != 0 {
+					log.Printf("Stopped watching %s, it was removed\n", dir)
+					return
+				}
+				if name != "" {
+					changed(name)
+				}
+			}
+		}
+	}()
+	return nil
+}
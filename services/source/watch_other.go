@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package source
+
+import (
+	"errors"
+)
+
+// Watching files requires inotify, sources fall back to periodic updates
+func watchDir(dir string, changed func(name string)) error {
+	return errors.New("watching files is only supported on Linux")
+}
@@ -0,0 +1,38 @@
+package template
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches the location text/template prefixes its errors with, e.g.
+// template: name:12:5: executing "name" at <.Id>: ...
+var errorLocationRegex = regexp.MustCompile("^template: .*?:(\\d+)(?::(\\d+))?: (.*)$")
+
+/* Describes a template error and the place of the template it occurred at */
+type RenderError struct {
+	// "parse" when the template is invalid, "execute" when it failed to
+	// render the data
+	Phase string
+	// 1-based line and column of the template, 0 when unknown
+	Line    int
+	Column  int
+	Message string
+}
+
+/* Extracts the phase and location of an error returned by RenderTemplate */
+func DescribeError(err error) RenderError {
+	message := err.Error()
+	described := RenderError{Phase: "parse", Message: message}
+
+	if match := errorLocationRegex.FindStringSubmatch(message); match != nil {
+		described.Line, _ = strconv.Atoi(match[1])
+		described.Column, _ = strconv.Atoi(match[2])
+		described.Message = match[3]
+	}
+	if strings.HasPrefix(described.Message, "executing ") {
+		described.Phase = "execute"
+	}
+	return described
+}
@@ -0,0 +1,91 @@
+package template
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/seomoz/roger-bamboo/services/marathon"
+)
+
+/* Returns the first health check of the app HAProxy can perform, nil
+when it has none. Marathon COMMAND checks run inside the task and
+cannot be reproduced by HAProxy. */
+func getHealthCheck(app marathon.App) *marathon.HealthCheck {
+	for i := range app.HealthChecks {
+		if app.HealthChecks[i].IsNetwork() {
+			return &app.HealthChecks[i]
+		}
+	}
+	return nil
+}
+
+/* Returns the backend settings matching the health check of the app:
+"option httpchk" for HTTP checks and "timeout check" for the
+timeout. E.g.
+	{{ range getHealthCheckOptions $app }}
+	{{ . }}{{ end }} */
+func getHealthCheckOptions(app marathon.App) []string {
+	options := []string{}
+	check := getHealthCheck(app)
+	if check == nil {
+		return options
+	}
+	if check.IsHTTP() {
+		path := check.Path
+		if path == "" {
+			path = "/"
+		}
+		options = append(options, "option httpchk GET "+path)
+	}
+	if check.TimeoutSeconds > 0 {
+		options = append(options, fmt.Sprintf("timeout check %ds", check.TimeoutSeconds))
+	}
+	return options
+}
+
+/* Returns the settings enabling the health check of the app on a
+server line, with a leading space, or an empty string when the app has
+no check HAProxy can perform. task is the task of the server, nil for
+an empty slot, and port_description the port of the task the server
+line uses, see getTaskPort. The checked port is only set when it
+differs from that port. Like the address, it is sent through the
+runtime API when tasks move, see haproxy.Topology. E.g.
+" check inter 60s fall 3" */
+func getServerCheck(app marathon.App, task interface{}, port_description string) string {
+	check := getHealthCheck(app)
+	if check == nil {
+		return ""
+	}
+
+	settings := []string{"check"}
+	if serverTask, ok := asTask(task); ok {
+		checkPort, hasPort := check.TaskPort(serverTask)
+		if hasPort && strconv.Itoa(checkPort) != getTaskPort(serverTask, port_description) {
+			settings = append(settings, "port "+strconv.Itoa(checkPort))
+		}
+	}
+	if check.IntervalSeconds > 0 {
+		settings = append(settings, fmt.Sprintf("inter %ds", check.IntervalSeconds))
+	}
+	if check.MaxConsecutiveFailures > 0 {
+		settings = append(settings, fmt.Sprintf("fall %d", check.MaxConsecutiveFailures))
+	}
+	if check.IsTLS() {
+		settings = append(settings, "check-ssl verify none")
+	}
+	return " " + strings.Join(settings, " ")
+}
+
+// Returns the task given to a template function, false for nil tasks
+func asTask(task interface{}) (marathon.Task, bool) {
+	switch task := task.(type) {
+	case marathon.Task:
+		return task, true
+	case *marathon.Task:
+		if task != nil {
+			return *task, true
+		}
+	}
+	return marathon.Task{}, false
+}
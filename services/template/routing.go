@@ -0,0 +1,124 @@
+package template
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/seomoz/roger-bamboo/services/marathon"
+	"github.com/seomoz/roger-bamboo/services/service"
+)
+
+/*
+	Returns the frontend lines routing the requests matching the service
+	to the backend of the app: its acls, then the use_backend line, or the
+	redirect line when the service redirects. A raw Acl of the service is
+	used as the "<EscapedId>-aclrule" acl. E.g.
+
+		{{ range getFrontendRules $app $service }}
+		{{ . }}{{ end }}
+*/
+func getFrontendRules(app marathon.App, serviceModel service.Service) []string {
+	name := app.EscapedId
+	routing := serviceModel.Routing
+	if routing == nil {
+		return []string{
+			"acl " + name + "-aclrule " + serviceModel.Acl,
+			"use_backend " + name + "-cluster if " + name + "-aclrule",
+		}
+	}
+
+	rules := []string{}
+	conditions := []string{}
+	if len(routing.Hosts) > 0 {
+		exact, domains := []string{}, []string{}
+		for _, host := range routing.Hosts {
+			if strings.HasPrefix(host, "*.") {
+				domains = append(domains, strings.TrimPrefix(host, "*"))
+			} else {
+				exact = append(exact, host)
+			}
+		}
+		// Acls sharing a name match when any of them does
+		if len(exact) > 0 {
+			rules = append(rules, "acl "+name+"-host hdr(host) -i "+strings.Join(exact, " "))
+		}
+		if len(domains) > 0 {
+			rules = append(rules, "acl "+name+"-host hdr_end(host) -i "+strings.Join(domains, " "))
+		}
+		conditions = append(conditions, name+"-host")
+	}
+	if len(routing.PathPrefixes) > 0 {
+		rules = append(rules, "acl "+name+"-path path_beg "+strings.Join(routing.PathPrefixes, " "))
+		conditions = append(conditions, name+"-path")
+	}
+	for i, header := range routing.Headers {
+		acl := fmt.Sprintf("%s-header-%d", name, i)
+		if header.Value == "" {
+			rules = append(rules, "acl "+acl+" req.hdr("+header.Name+") -m found")
+		} else {
+			rules = append(rules, "acl "+acl+" req.hdr("+header.Name+") "+header.Value)
+		}
+		conditions = append(conditions, acl)
+	}
+
+	condition := strings.Join(conditions, " ")
+	if redirect := routing.Redirect; redirect != nil {
+		code := redirect.Code
+		if code == 0 {
+			code = 302
+		}
+		kind := "location"
+		if redirect.Prefix {
+			kind = "prefix"
+		}
+		return append(rules, "redirect "+kind+" "+redirect.Location+" code "+strconv.Itoa(code)+" if "+condition)
+	}
+	return append(rules, "use_backend "+name+"-cluster if "+condition)
+}
+
+/*
+	Returns the backend settings of the service: its timeouts, and the
+	reqrep lines stripping the matched path prefix and rewriting the path.
+	The prefixes are stripped by a single reqrep trying the longest first,
+	so that a request is only stripped of the prefix it matched. E.g.
+
+		{{ range getBackendRules $service }}
+		{{ . }}{{ end }}
+*/
+func getBackendRules(serviceModel service.Service) []string {
+	rules := []string{}
+	routing := serviceModel.Routing
+	if routing == nil {
+		return rules
+	}
+
+	if timeouts := routing.Timeouts; timeouts != nil {
+		if timeouts.Connect != "" {
+			rules = append(rules, "timeout connect "+timeouts.Connect)
+		}
+		if timeouts.Server != "" {
+			rules = append(rules, "timeout server "+timeouts.Server)
+		}
+	}
+	if routing.StripPrefix && len(routing.PathPrefixes) > 0 {
+		prefixes := []string{}
+		for _, prefix := range routing.PathPrefixes {
+			prefixes = append(prefixes, regexp.QuoteMeta(strings.TrimRight(prefix, "/")))
+		}
+		sort.Stable(byLengthDesc(prefixes))
+		rules = append(rules, `reqrep ^([^\ :]*\ )(`+strings.Join(prefixes, "|")+`)/?(.*) \1/\3`)
+	}
+	if rewrite := routing.Rewrite; rewrite != nil {
+		rules = append(rules, `reqrep ^([^\ :]*\ )`+regexp.QuoteMeta(rewrite.From)+`(.*) \1`+rewrite.To+`\2`)
+	}
+	return rules
+}
+
+type byLengthDesc []string
+
+func (slice byLengthDesc) Len() int           { return len(slice) }
+func (slice byLengthDesc) Less(i, j int) bool { return len(slice[i]) > len(slice[j]) }
+func (slice byLengthDesc) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
@@ -3,6 +3,7 @@ package template
 import (
 	"bytes"
 	"fmt"
+	"github.com/seomoz/roger-bamboo/services/marathon"
 	"github.com/seomoz/roger-bamboo/services/service"
 	"hash/fnv"
 	"regexp"
@@ -36,16 +37,27 @@ func getTime() string {
 	return time.Now().String()
 }
 
-/* Given the array of local ports on a task and a string describing
+/* Given a task (or the array of its ports) and a string describing
 the port to be picked. Returns the appropriate port. If
 port_description is a number then its returned as is. If
 port_description is for the form PORTX where X is a number, returns
-the value Ports[X]. If port_description has any other format, a
-panic is raise. The lack of error checking on the indexing is
-deliberate and intended to cause the template rendering to fail
-rather than have an incorrect value.  E.g given Ports = [21334,
-312333] and port_description = PORT0 will return 21334*/
-func getTaskPort(Ports []int, port_description string) string {
+the value Ports[X]. Otherwise port_description is looked up in the
+NamedPorts of the task. If no port matches, a panic is raised. The
+lack of error checking on the indexing is deliberate and intended to
+cause the template rendering to fail rather than have an incorrect
+value.  E.g given Ports = [21334, 312333] and port_description =
+PORT0 will return 21334*/
+func getTaskPort(task interface{}, port_description string) string {
+	var Ports []int
+	var namedPorts map[string]int
+	if ports, ok := task.([]int); ok {
+		Ports = ports
+	} else if task, ok := asTask(task); ok {
+		Ports, namedPorts = task.Ports, task.NamedPorts
+	} else {
+		panic(fmt.Sprintf("getTaskPort expects a task or its ports, got %T", task))
+	}
+
 	// If the port desctiption is of the form PORTX
 	if taskPortRegex.MatchString(port_description) {
 		match := taskPortRegex.FindStringSubmatch(port_description)
@@ -60,6 +72,10 @@ func getTaskPort(Ports []int, port_description string) string {
 		// Return the port number itself.
 		return port_description
 	}
+	// If the port description is the name of a port
+	if port, ok := namedPorts[port_description]; ok {
+		return strconv.Itoa(port)
+	}
 
 	// The port_description is not valid
 	panic(fmt.Sprintf("Invalid port_description %s", port_description))
@@ -116,17 +132,64 @@ func getConditionsDescending(backendrules map[string]string) []string {
 	return conditions
 }
 
+/* A server line of a backend, holding a task or kept empty for later use */
+type ServerSlot struct {
+	// 1-based position of the slot in the backend
+	Index int
+	// nil when the slot is empty
+	Task *marathon.Task
+}
+
+/* Given the tasks of a backend and a slot count, returns the tasks laid
+out in slots. The number of slots is the smallest multiple of
+slotCount holding every task, so that it only changes when an app
+scales past it. Slots named after their index keep the rendered
+config topology stable when tasks move, which lets Bamboo apply the
+change through the HAProxy runtime API instead of a reload. */
+func getServerSlots(tasks []marathon.Task, slotCount int) []ServerSlot {
+	if slotCount < 1 {
+		slotCount = 1
+	}
+	total := slotCount
+	if len(tasks) > slotCount {
+		total = (len(tasks) + slotCount - 1) / slotCount * slotCount
+	}
+
+	slots := make([]ServerSlot, total)
+	for i := range slots {
+		slots[i].Index = i + 1
+		if i < len(tasks) {
+			slots[i].Task = &tasks[i]
+		}
+	}
+	return slots
+}
+
+// The line of the templates holding the time they were rendered at
+const RenderTimeLine = "# Template rendered at {{ getTime }}"
+
+/*
+	Returns the template without the line holding the render time, so that
+	the rendered content only depends on the template data.
+*/
+func IdempotentTemplate(templateContent string) string {
+	return strings.Replace(templateContent, RenderTimeLine, "", 1)
+}
+
 /*
 	Returns string content of a rendered template
 */
 func RenderTemplate(templateName string, templateContent string, data interface{}) (string, error) {
-	funcMap := template.FuncMap{"hasKey": hasKey, "getService": getService, "getTime": getTime, "getTaskPort": getTaskPort, "getServerHash": getServerHash, "getHash": getHash, "escapeSlashes": escapeSlashes, "addAcl": addAcl, "addBackendRule": addBackendRule, "getConditionsDescending": getConditionsDescending }
+	funcMap := template.FuncMap{"hasKey": hasKey, "getService": getService, "getTime": getTime, "getTaskPort": getTaskPort, "getServerHash": getServerHash, "getHash": getHash, "escapeSlashes": escapeSlashes, "addAcl": addAcl, "addBackendRule": addBackendRule, "getConditionsDescending": getConditionsDescending, "getServerSlots": getServerSlots, "getHealthCheck": getHealthCheck, "getHealthCheckOptions": getHealthCheckOptions, "getServerCheck": getServerCheck, "getFrontendRules": getFrontendRules, "getBackendRules": getBackendRules }
 
-	tpl := template.Must(template.New(templateName).Funcs(funcMap).Parse(templateContent))
+	tpl, err := template.New(templateName).Funcs(funcMap).Parse(templateContent)
+	if err != nil {
+		return "", err
+	}
 
 	strBuffer := new(bytes.Buffer)
 
-	err := tpl.Execute(strBuffer, data)
+	err = tpl.Execute(strBuffer, data)
 	if err != nil {
 		return "", err
 	}
@@ -3,6 +3,7 @@ package template
 import (
 	"testing"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/seomoz/roger-bamboo/services/marathon"
 )
 
 func TestTemplateWriter(t *testing.T) {
@@ -15,5 +16,99 @@ func TestTemplateWriter(t *testing.T) {
 			content, _ := RenderTemplate(templateName, templateContent, params)
 			So(content, ShouldEqual, "app example.com")
 		})
+
+		Convey("should return parse errors with their line", func() {
+			_, err := RenderTemplate(templateName, "{{.id}}\n{{ .domain }", params)
+			described := DescribeError(err)
+			So(described.Phase, ShouldEqual, "parse")
+			So(described.Line, ShouldEqual, 2)
+		})
+
+		Convey("should return execution errors with their line and column", func() {
+			_, err := RenderTemplate(templateName, "{{.id}}\n\n  {{ getTaskPort .ports \"PORT1\" }}", map[string][]int{"ports": {1}})
+			described := DescribeError(err)
+			So(described.Phase, ShouldEqual, "execute")
+			So(described.Line, ShouldEqual, 3)
+			So(described.Column, ShouldEqual, 5)
+			So(described.Message, ShouldContainSubstring, "getTaskPort")
+		})
+	})
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	Convey("#getTaskPort", t, func() {
+		Convey("should pick the port of the task by index", func() {
+			So(getTaskPort([]int{31000, 31001}, "PORT1"), ShouldEqual, "31001")
+		})
+
+		Convey("should return port numbers as is", func() {
+			So(getTaskPort([]int{31000}, "8080"), ShouldEqual, "8080")
+		})
+
+		Convey("should resolve port names of tasks", func() {
+			task := marathon.Task{Ports: []int{31000, 31001}, NamedPorts: map[string]int{"admin": 31001}}
+			So(getTaskPort(task, "admin"), ShouldEqual, "31001")
+			So(getTaskPort(&task, "PORT0"), ShouldEqual, "31000")
+			So(func() { getTaskPort(task, "metrics") }, ShouldPanic)
+		})
+
+		Convey("should panic on invalid descriptions", func() {
+			So(func() { getTaskPort([]int{31000}, "http") }, ShouldPanic)
+			So(func() { getTaskPort([]int{31000}, "PORT1") }, ShouldPanic)
+		})
+	})
+
+	Convey("#getServerHash", t, func() {
+		Convey("should be stable for a server", func() {
+			So(getServerHash("::app", "10.0.0.1", 31000), ShouldEqual, getServerHash("::app", "10.0.0.1", 31000))
+		})
+
+		Convey("should differ across servers", func() {
+			So(getServerHash("::app", "10.0.0.1", 31000), ShouldNotEqual, getServerHash("::app", "10.0.0.1", 31001))
+		})
+	})
+
+	Convey("#addBackendRule", t, func() {
+		Convey("should keep the last backend of a condition, listed in descending order", func() {
+			content, _ := RenderTemplate("rules", `{{ addBackendRule .Rules "a" "/a" }}{{ addBackendRule .Rules "b" "/a/b" }}{{ addBackendRule .Rules "c" "/a" }}`+
+				`{{ range getConditionsDescending .Rules }}{{ . }}={{ index $.Rules . }} {{ end }}`,
+				map[string]map[string]string{"Rules": {}})
+			So(content, ShouldEqual, "/a/b=b /a=c ")
+		})
+	})
+}
+
+func TestHealthCheckFuncs(t *testing.T) {
+	Convey("#getServerCheck", t, func() {
+		admin := 1
+		app := marathon.App{HealthChecks: []marathon.HealthCheck{
+			{Protocol: marathon.ProtocolCommand, IntervalSeconds: 5},
+			{Protocol: marathon.ProtocolHTTPS, Path: "/status", PortIndex: &admin, IntervalSeconds: 30, TimeoutSeconds: 10, MaxConsecutiveFailures: 3},
+		}}
+		task := &marathon.Task{Ports: []int{31000, 31001}}
+
+		Convey("should skip checks HAProxy cannot perform", func() {
+			So(getHealthCheck(app).Protocol, ShouldEqual, marathon.ProtocolHTTPS)
+			So(getHealthCheck(marathon.App{HealthChecks: app.HealthChecks[:1]}), ShouldBeNil)
+		})
+
+		Convey("should render the backend options", func() {
+			So(getHealthCheckOptions(app), ShouldResemble, []string{"option httpchk GET /status", "timeout check 10s"})
+			So(getHealthCheckOptions(marathon.App{}), ShouldBeEmpty)
+		})
+
+		Convey("should only set the checked port when it is not the served one", func() {
+			So(getServerCheck(app, task, "PORT0"), ShouldEqual, " check port 31001 inter 30s fall 3 check-ssl verify none")
+			So(getServerCheck(app, task, "PORT1"), ShouldEqual, " check inter 30s fall 3 check-ssl verify none")
+		})
+
+		Convey("should render empty slots like served ones", func() {
+			var empty *marathon.Task
+			So(getServerCheck(app, empty, "PORT1"), ShouldEqual, getServerCheck(app, task, "PORT1"))
+		})
+
+		Convey("should be empty without a check", func() {
+			So(getServerCheck(marathon.App{}, task, "PORT0"), ShouldEqual, "")
+		})
 	})
 }
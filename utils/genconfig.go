@@ -10,7 +10,10 @@ import (
 
 	"github.com/samuel/go-zookeeper/zk"
 	"github.com/seomoz/roger-bamboo/configuration"
+	"github.com/seomoz/roger-bamboo/qzk"
 	"github.com/seomoz/roger-bamboo/services/haproxy"
+	"github.com/seomoz/roger-bamboo/services/service"
+	"github.com/seomoz/roger-bamboo/services/source"
 	"github.com/seomoz/roger-bamboo/services/template"
 	lumberjack "github.com/natefinch/lumberjack"
 )
@@ -19,10 +22,16 @@ import (
 /* Commandline arguments */
 var configFilePath string
 var logPath string
+var snapshotPath string
+var fromSnapshotPath string
+var outputPath string
 
 func init() {
 	flag.StringVar(&configFilePath, "config", "config/development.json", "Full path of the configuration JSON file")
 	flag.StringVar(&logPath, "log", "", "Log path to a file. Default logs to stdout")
+	flag.StringVar(&snapshotPath, "snapshot", "", "Records the template data fetched from Marathon and Zookeeper to this JSON file")
+	flag.StringVar(&fromSnapshotPath, "from-snapshot", "", "Renders the template from a JSON file recorded with --snapshot, without network access")
+	flag.StringVar(&outputPath, "output", "", "Writes the rendered config to this file. Default logs it")
 }
 
 func main() {
@@ -41,25 +50,66 @@ func main() {
 	templateContent, err := ioutil.ReadFile(conf.HAProxy.TemplatePath)
 	if err != nil { log.Panicf("Cannot read template file: %s", err) }
 
-	zkConf := conf.Bamboo.Zookeeper
-	//log.Println("Connecting to Zookeeper using " + zkConf.ConnectionString())
-	conn, _, err := zk.Connect(zkConf.ConnectionString(), time.Second*10)
-	if err != nil {
-		log.Panic(err)
-	}
+	var templateData haproxy.TemplateData
+	if len(fromSnapshotPath) > 0 {
+		// Get the App config data from a recorded snapshot.
+		log.Println("Loading template data from " + fromSnapshotPath)
+		templateData, err = haproxy.ReadSnapshot(fromSnapshotPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		var conn *zk.Conn
+		if conf.Bamboo.UsesZookeeper() {
+			zkConf := conf.Bamboo.Zookeeper
+			//log.Println("Connecting to Zookeeper using " + zkConf.ConnectionString())
+			conn, err = qzk.Connect(zkConf, time.Second*10)
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+
+		store, err := service.NewStore(&conf, conn)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	// Get the App config data from Marathon.
-	templateData := haproxy.GetTemplateData(&conf, conn)
+		src, err := source.New(&conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Get the App config data from the configured sources.
+		templateData, err = haproxy.GetTemplateData(&conf, store, conn, src)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	if templateData.Apps == nil || len(templateData.Apps) == 0  {
 		log.Println("Got no Apps in template data. Skipping rendering template");
 		return
 	}
 
+	if len(snapshotPath) > 0 {
+		if err := haproxy.WriteSnapshot(snapshotPath, templateData); err != nil {
+			log.Fatalf("Cannot write snapshot: %s", err)
+		}
+		log.Println("Recorded template data to " + snapshotPath)
+	}
+
 	// Render the template
 	newContent, err := template.RenderTemplate(conf.HAProxy.TemplatePath, string(templateContent), templateData)
 	if err != nil { log.Fatalf("Template syntax error: \n %s", err ) }
 
+	if len(outputPath) > 0 {
+		if err := ioutil.WriteFile(outputPath, []byte(newContent), 0644); err != nil {
+			log.Fatalf("Cannot write rendered config: %s", err)
+		}
+		log.Println("Wrote HAProxy config to " + outputPath)
+		return
+	}
+
 	// Write the rendered template.
 	log.Println("===============Begin HAProxy config===========================");
 	log.Println(newContent);
@@ -1,4 +1,4 @@
-module.exports = ["$resource", function ($resource) {
+module.exports = ["$resource", "$http", "$q", function ($resource, $http, $q) {
   var index = $resource("/api/services", {},
     {
       get: { method: "GET" },
@@ -6,14 +6,29 @@ module.exports = ["$resource", function ($resource) {
 
     });
 
-  var entity = $resource("/api/services/:id", { id: "@id" }, {
-    update: { method: "PUT", params: { id: "@id"} },
-    destroy: { method: "DELETE", params: { id: "@id"} }
-  });
+  var entityUrl = function (id) {
+    return "/api/services/" + encodeURIComponent(id);
+  };
+
+  // Changes the service only as long as it still has the version it was read with
+  var ifMatch = function (version) {
+    return { headers: { "If-Match": '"' + version + '"' } };
+  };
 
-  var encodeId = function (params) {
-    params.id = encodeURIComponent(params.id);
-    return params;
+  // Never overwrites a service whose version is unknown
+  var missingVersion = function () {
+    return $q.reject({
+      status: 0,
+      data: "The version of this service is unknown. Reload to get it, then try again."
+    });
+  };
+
+  var handleConflict = function (response) {
+    if (response.status === 409) {
+      response.conflict = true;
+      response.data = "This service was changed by someone else meanwhile. Reload to see the changes, then try again.";
+    }
+    return $q.reject(response);
   };
 
   return {
@@ -26,11 +41,19 @@ module.exports = ["$resource", function ($resource) {
     },
 
     update: function (params) {
-      return entity.update(encodeId(params)).$promise;
+      if (!params.version) {
+        return missingVersion();
+      }
+      return $http.put(entityUrl(params.id), { id: params.id, acl: params.acl }, ifMatch(params.version))
+        .catch(handleConflict);
     },
 
     destroy: function (params) {
-      return entity.destroy(encodeId(params)).$promise;
+      if (!params.version) {
+        return missingVersion();
+      }
+      return $http.delete(entityUrl(params.id), ifMatch(params.version))
+        .catch(handleConflict);
     }
   }
-}];
\ No newline at end of file
+}];
@@ -3,12 +3,14 @@ module.exports = function () {
     restrict: "AE",
     template:  '<button class="btn btn-danger" ng-click="showModal()" title="Delete"><i class="icon ion-android-trash"></i></button>',
     scope: {
-      serviceId: "="
+      serviceId: "=",
+      serviceVersion: "="
     },
     controller: ["$scope", "Service", "$modal", "$rootScope", function ($scope, Service, $modal, $rootScope) {
       $scope.actionName = "Delete It!";
 
       $scope.showModal = function () {
+        $scope.actionError = null;
         $scope.modal = $modal({
           title: "Are you sure?",
           template: "bamboo/modal-confirm",
@@ -20,12 +22,15 @@ module.exports = function () {
 
       $scope.doAction = function () {
         Service.destroy({
-            id: $scope.serviceId
+            id: $scope.serviceId,
+            version: $scope.serviceVersion
           })
           .then(function () {
             $scope.modal.hide();
             $scope.modal = null;
             $rootScope.$broadcast("services.reset");
+          }, function (payload) {
+            $scope.actionError = payload.data;
           });
       };
     }]
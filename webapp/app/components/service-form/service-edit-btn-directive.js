@@ -16,7 +16,8 @@ module.exports = ["Service", function (Service) {
 
       scope.service = {
         id: scope.serviceModel.id,
-        acl: scope.serviceModel.service.Acl
+        acl: scope.serviceModel.service.Acl,
+        version: scope.serviceModel.service.Version
       };
 
       var modalOptions = {
@@ -30,7 +30,8 @@ module.exports = ["$scope", "$modal", "$rootScope", function ($scope, $modal, $r
     $scope.loading = true;
     $scope.makeRequest({
         id: $scope.service.id,
-        acl: $scope.service.acl
+        acl: $scope.service.acl,
+        version: $scope.service.version
       })
      .then(handleSuccess, handleError);
   };
@@ -14,6 +14,31 @@ module.exports = function () {
 
         return "-";
       };
+
+      // Routing rules are shown, not edited, as the form only knows raw ACLs
+      $scope.routingSummary = function () {
+        var routing = $scope.serviceModel.service && $scope.serviceModel.service.Routing;
+        if (!routing) {
+          return "";
+        }
+
+        var parts = [];
+        if (routing.Hosts) {
+          parts.push("hosts " + routing.Hosts.join(", "));
+        }
+        if (routing.PathPrefixes) {
+          parts.push("paths " + routing.PathPrefixes.join(", "));
+        }
+        if (routing.Headers) {
+          parts.push("headers " + routing.Headers.map(function (header) {
+            return header.Value ? header.Name + ": " + header.Value : header.Name;
+          }).join(", "));
+        }
+        if (routing.Redirect) {
+          parts.push("redirect to " + routing.Redirect.Location);
+        }
+        return parts.join("; ");
+      };
     }],
     template: require("./service-item.html")
   };
@@ -23,17 +23,17 @@ module.exports=require('HlZQrA');
 },{}],11:[function(require,module,exports){
 var ServiceListModule=require("./components/service-list/service-list.js"),ServiceFormModule=require("./components/service-form/service-form.js"),bambooApp=angular.module("bamboo",[ServiceListModule.name,ServiceFormModule.name]).factory("State",require("./components/resources/state-resource")).factory("Service",require("./components/resources/service-resource")).run(["$templateCache",function(e){e.put("bamboo/modal-confirm",require("./components/modal/modal-confirm.html"))}]);module.exports=bambooApp;
 },{"./components/modal/modal-confirm.html":12,"./components/resources/service-resource":13,"./components/resources/state-resource":14,"./components/service-form/service-form.js":19,"./components/service-list/service-list.js":26}],12:[function(require,module,exports){
-module.exports='<div class="modal" tabindex="-1" role="dialog">\n    <div class="modal-dialog">\n        <div class="modal-content">\n            <div class="modal-header" ng-show="title">\n                <button type="button" class="close" ng-click="$hide()">&times;</button>\n                <h4 class="modal-title" ng-bind="title"></h4>\n            </div>\n            <div class="modal-body" ng-bind="content"></div>\n            <div class="modal-footer">\n\n                <button type="button" class="btn btn-default" ng-click="$hide()">Close</button>\n\n                <button type="button" class="btn btn-primary" ng-click="doAction()">{{ actionName }}</button>\n            </div>\n        </div>\n    </div>\n</div>';
+module.exports='<div class="modal" tabindex="-1" role="dialog">\n    <div class="modal-dialog">\n        <div class="modal-content">\n            <div class="modal-header" ng-show="title">\n                <button type="button" class="close" ng-click="$hide()">&times;</button>\n                <h4 class="modal-title" ng-bind="title"></h4>\n            </div>\n            <div class="modal-body" ng-bind="content"></div>\n            <div class="modal-body text-danger" ng-show="actionError" ng-bind="actionError"></div>\n            <div class="modal-footer">\n\n                <button type="button" class="btn btn-default" ng-click="$hide()">Close</button>\n\n                <button type="button" class="btn btn-primary" ng-click="doAction()">{{ actionName }}</button>\n            </div>\n        </div>\n    </div>\n</div>';
 },{}],13:[function(require,module,exports){
-module.exports=["$resource",function(e){var r=e("/api/services",{},{get:{method:"GET"},create:{method:"POST"}}),t=e("/api/services/:id",{id:"@id"},{update:{method:"PUT",params:{id:"@id"}},destroy:{method:"DELETE",params:{id:"@id"}}}),i=function(e){return e.id=encodeURIComponent(e.id),e};return{all:function(){return r.get().$promise},create:function(e){return r.create(e).$promise},update:function(e){return t.update(i(e)).$promise},destroy:function(e){return t.destroy(i(e)).$promise}}}];
+module.exports=["$resource","$http","$q",function(e,r,t){var i=e("/api/services",{},{get:{method:"GET"},create:{method:"POST"}}),n=function(e){return"/api/services/"+encodeURIComponent(e)},o=function(e){return{headers:{"If-Match":'"'+e+'"'}}},s=function(){return t.reject({status:0,data:"The version of this service is unknown. Reload to get it, then try again."})},c=function(e){return 409===e.status&&(e.conflict=!0,e.data="This service was changed by someone else meanwhile. Reload to see the changes, then try again."),t.reject(e)};return{all:function(){return i.get().$promise},create:function(e){return i.create(e).$promise},update:function(e){return e.version?r.put(n(e.id),{id:e.id,acl:e.acl},o(e.version))["catch"](c):s()},destroy:function(e){return e.version?r["delete"](n(e.id),o(e.version))["catch"](c):s()}}}];
 },{}],14:[function(require,module,exports){
 module.exports=["$resource",function(e){var r=e("/api/state",{});return{get:function(){return r.get().$promise}}}];
 },{}],15:[function(require,module,exports){
-module.exports=function(){return{restrict:"AE",template:'<button class="btn btn-danger" ng-click="showModal()" title="Delete"><i class="icon ion-android-trash"></i></button>',scope:{serviceId:"="},controller:["$scope","Service","$modal","$rootScope",function(e,o,t,n){e.actionName="Delete It!",e.showModal=function(){e.modal=t({title:"Are you sure?",template:"bamboo/modal-confirm",content:"Delete Marathon ID "+e.serviceId,scope:e,show:!0})},e.doAction=function(){o.destroy({id:e.serviceId}).then(function(){e.modal.hide(),e.modal=null,n.$broadcast("services.reset")})}}]}};
+module.exports=function(){return{restrict:"AE",template:'<button class="btn btn-danger" ng-click="showModal()" title="Delete"><i class="icon ion-android-trash"></i></button>',scope:{serviceId:"=",serviceVersion:"="},controller:["$scope","Service","$modal","$rootScope",function(e,o,t,n){e.actionName="Delete It!",e.showModal=function(){e.actionError=null,e.modal=t({title:"Are you sure?",template:"bamboo/modal-confirm",content:"Delete Marathon ID "+e.serviceId,scope:e,show:!0})},e.doAction=function(){o.destroy({id:e.serviceId,version:e.serviceVersion}).then(function(){e.modal.hide(),e.modal=null,n.$broadcast("services.reset")},function(o){e.actionError=o.data})}}]}};
 },{}],16:[function(require,module,exports){
-module.exports=["Service",function(e){return{restrict:"AE",template:'<button class="btn btn-default" title="Edit" ng-click="new()"><i class="icon ion-compose"></i></button>',scope:{serviceModel:"="},controller:require("./service-form-ctrl.js"),link:function(t){t.actionName="Update",t.disableMarathonIdChange=!0,t.service={id:t.serviceModel.id,acl:t.serviceModel.service.Acl};var o={title:"Edit service configuration",template:"bamboo/modal-confirm",contentTemplate:"bamboo/service-form",scope:t,show:!1,html:!0};t.new=function(){t.showModal(o)},t.makeRequest=function(t){return e.update(t)}}}}];
+module.exports=["Service",function(e){return{restrict:"AE",template:'<button class="btn btn-default" title="Edit" ng-click="new()"><i class="icon ion-compose"></i></button>',scope:{serviceModel:"="},controller:require("./service-form-ctrl.js"),link:function(t){t.actionName="Update",t.disableMarathonIdChange=!0,t.service={id:t.serviceModel.id,acl:t.serviceModel.service.Acl,version:t.serviceModel.service.Version};var o={title:"Edit service configuration",template:"bamboo/modal-confirm",contentTemplate:"bamboo/service-form",scope:t,show:!1,html:!0};t.new=function(){t.showModal(o)},t.makeRequest=function(t){return e.update(t)}}}}];
 },{"./service-form-ctrl.js":17}],17:[function(require,module,exports){
-module.exports=["$scope","$modal","$rootScope",function(o,e,n){o.showModal=function(n){var a;o.modal=a=e(n),a.$promise.then(a.show)},o.loading=!1;var a=function(){o.errors=null},i=function(){o.loading=!1,o.modal.hide(),o.modal=null,n.$broadcast("services.reset")},d=function(e){o.loading=!1,o.errors=e.data};o.doAction=function(){a(),o.loading=!0,o.makeRequest({id:o.service.id,acl:o.service.acl}).then(i,d)}}];
+module.exports=["$scope","$modal","$rootScope",function(o,e,n){o.showModal=function(n){var a;o.modal=a=e(n),a.$promise.then(a.show)},o.loading=!1;var a=function(){o.errors=null},i=function(){o.loading=!1,o.modal.hide(),o.modal=null,n.$broadcast("services.reset")},d=function(e){o.loading=!1,o.errors=e.data};o.doAction=function(){a(),o.loading=!0,o.makeRequest({id:o.service.id,acl:o.service.acl,version:o.service.version}).then(i,d)}}];
 },{}],18:[function(require,module,exports){
 module.exports='<form role="form">\n    <div class="inner-form" ng-class="{\'has-error\': errors }">\n        <div class="form-group">\n            <label>Marathon ID</label>\n            <input ng-model="service.id" type="text" class="form-control"  name="input" ng-disabled="loading || disableMarathonIdChange" ng-required="true">\n        </div>\n        <div class="form-group">\n            <label>ACL</label>\n            <p class="help-block">\n                Enter HAProxy acl\'s criterion, flag and operator (<a target="_blank" href="http://cbonte.github.io/haproxy-dconv/configuration-1.5.html#7">acl documentation</a>), for example: <br/>\n                DNS approach: <code>hdr(host) -i app.example.com</code> <br/>\n                Path prefix: <code>path_beg -i /app-group/app1</code>\n            </p>\n            <div class="input-group">\n                <div class="input-group-addon">acl &lt;aclname&gt;</div>\n                <input ng-model="service.acl" type="text" class="form-control"  name="input" ng-disabled="loading" ng-required="true" placeholder="hdr(host) -i app.example.com">\n            </div>\n        </div>\n        <span ng-show="errors" class="help-block">{{ errors }}</span>\n    </div>\n</form>';
 },{}],19:[function(require,module,exports){
@@ -41,9 +41,9 @@ var ngModule=angular.module("bamboo.ServiceForm",[]).directive("serviceNewBtn",r
 },{"./service-delete-btn-directive.js":15,"./service-edit-btn-directive.js":16,"./service-form.html":18,"./service-new-btn-directive.js":20}],20:[function(require,module,exports){
 module.exports=["Service",function(e){return{restrict:"AE",controller:require("./service-form-ctrl.js"),template:function(e,t){var r=t.hasOwnProperty("text")?t.text:'<i class="icon ion-plus"></i> New';return'<button class="btn btn-primary btn-create-service" ng-click="new()">'+r+"</button>"},scope:{serviceModel:"=?"},link:function(t){t.actionName="Create",t.service={id:t.serviceModel?t.serviceModel.id||"":"",acl:""};var r={title:"Create new service configuration",template:"bamboo/modal-confirm",contentTemplate:"bamboo/service-form",scope:t,animation:"am-fade-and-scale",show:!1,html:!0};t.new=function(){t.showModal(r)},t.makeRequest=function(t){return e.create(t)}}}}];
 },{"./service-form-ctrl.js":17}],21:[function(require,module,exports){
-module.exports=function(){return{restrict:"AE",replace:!0,scope:{serviceModel:"="},controller:["$scope",function(e){e.instancesCount=function(){return e.serviceModel.app?e.serviceModel.app.Tasks.length:"-"}}],template:require("./service-item.html")}};
+module.exports=function(){return{restrict:"AE",replace:!0,scope:{serviceModel:"="},controller:["$scope",function(e){e.instancesCount=function(){return e.serviceModel.app?e.serviceModel.app.Tasks.length:"-"},e.routingSummary=function(){var r=e.serviceModel.service&&e.serviceModel.service.Routing;if(!r)return"";var o=[];return r.Hosts&&o.push("hosts "+r.Hosts.join(", ")),r.PathPrefixes&&o.push("paths "+r.PathPrefixes.join(", ")),r.Headers&&o.push("headers "+r.Headers.map(function(e){return e.Value?e.Name+": "+e.Value:e.Name}).join(", ")),r.Redirect&&o.push("redirect to "+r.Redirect.Location),o.join("; ")}}],template:require("./service-item.html")}};
 },{"./service-item.html":22}],22:[function(require,module,exports){
-module.exports='<div class="row service-item service-action-type-{{serviceModel.actionType}}">\n    <span ng-bind="serviceModel.id" class="col-xs-4"></span>\n    <span ng-bind="serviceModel.service.Acl" class="col-xs-4"></span>\n\n    <span ng-bind="instancesCount()" class="col-xs-1 col-instances-count"></span>\n\n    <span class="col-xs-3 item-actions" ng-switch="serviceModel.actionType">\n        <span ng-switch-when="default" class="item-actions-group">\n            <service-edit-btn service-model="serviceModel"></service-edit-btn>\n            <service-delete-btn service-id="serviceModel.service.Id"></service-delete-btn>\n        </span>\n\n        <span ng-switch-when="marathon" class="item-actions-group">\n            <i class="message"> Missing app in Marathon </i>\n            <service-delete-btn service-id="serviceModel.service.Id"></service-delete-btn>\n        </span>\n\n        <span ng-switch-when="service" class="item-actions-group">\n            <i class="message"> Using default proxy rule</i>\n            <service-new-btn service-model="serviceModel" title="Create a new service mapping to Marathon ID" text="<i class=\'icon ion-plus-round\'><i>" ></service-new-btn>\n        </span>\n    </span>\n</div>';
+module.exports='<div class="row service-item service-action-type-{{serviceModel.actionType}}">\n    <span ng-bind="serviceModel.id" class="col-xs-4"></span>\n    <span ng-if="!serviceModel.service.Routing" ng-bind="serviceModel.service.Acl" class="col-xs-4"></span>\n    <span ng-if="serviceModel.service.Routing" ng-bind="routingSummary()" class="col-xs-4" title="Routing rules, managed through the API"></span>\n\n    <span ng-bind="instancesCount()" class="col-xs-1 col-instances-count"></span>\n\n    <span class="col-xs-3 item-actions" ng-switch="serviceModel.actionType">\n        <span ng-switch-when="default" class="item-actions-group">\n            <service-edit-btn ng-if="!serviceModel.service.Routing" service-model="serviceModel"></service-edit-btn>\n            <i ng-if="serviceModel.service.Routing" class="message"> Routing rules, edit through the API </i>\n            <service-delete-btn service-id="serviceModel.service.Id" service-version="serviceModel.service.Version"></service-delete-btn>\n        </span>\n\n        <span ng-switch-when="marathon" class="item-actions-group">\n            <i class="message"> Missing app in Marathon </i>\n            <service-delete-btn service-id="serviceModel.service.Id" service-version="serviceModel.service.Version"></service-delete-btn>\n        </span>\n\n        <span ng-switch-when="service" class="item-actions-group">\n            <i class="message"> Using default proxy rule</i>\n            <service-new-btn service-model="serviceModel" title="Create a new service mapping to Marathon ID" text="<i class=\'icon ion-plus-round\'><i>" ></service-new-btn>\n        </span>\n    </span>\n</div>';
 },{}],23:[function(require,module,exports){
 var ngModule=angular.module("bamboo.ServiceItem",[]).directive("serviceItem",require("./service-item-directive.js"));module.exports=ngModule;
 },{"./service-item-directive.js":21}],24:[function(require,module,exports){